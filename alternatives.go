@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// alternative_list_item is a candidate command in the alternatives screen
+type alternative_list_item struct {
+	response command_response
+	summary  string
+}
+
+func (i alternative_list_item) Title() string       { return i.response.Command }
+func (i alternative_list_item) Description() string { return i.summary }
+func (i alternative_list_item) FilterValue() string { return i.response.Command }
+
+type GPTalternativesResult struct {
+	request_id int
+	responses  []command_response
+}
+
+type GPTalternativesError struct {
+	request_id int
+	err        error
+}
+
+func makeGPTalternativesRequest(ctx context.Context, provider Provider, history []chat_message, prompt string, n int, request_id int) tea.Cmd {
+	return func() tea.Msg {
+		responses, err := provider.GenerateAlternatives(ctx, history, prompt, n)
+		if err != nil {
+			return GPTalternativesError{request_id: request_id, err: err}
+		}
+
+		return GPTalternativesResult{request_id: request_id, responses: responses}
+	}
+}
+
+/**
+* Short description of a candidate command for the alternatives list,
+* eg: "Deletes the logs · find → xargs → rm · ⚠︎ dangerous · not installed: fd"
+**/
+func summarizeCommand(response command_response) string {
+	command := response.Command
+
+	var programs []string
+	seen := map[string]bool{}
+
+	for _, c := range parseShellCommands(command) {
+		name, _ := c.executable()
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		programs = append(programs, name)
+	}
+
+	var parts []string
+	if response.Description != "" {
+		parts = append(parts, response.Description)
+	}
+	parts = append(parts, strings.Join(programs, " → "))
+
+	if len(analyzeCommandRisk(command)) > 0 {
+		parts = append(parts, "⚠︎ dangerous")
+	}
+
+	if missing := findMissingTools(command, response.RequiredTools); len(missing) > 0 {
+		parts = append(parts, "not installed: "+strings.Join(missing, ", "))
+	}
+
+	return strings.Join(parts, " · ")
+}
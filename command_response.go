@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// command_response is the structured answer asked to the model for a prompt
+type command_response struct {
+	Command     string `json:"command"`
+	Description string `json:"description"`
+	// Risk is the model's own assessment: "low", "medium" or "high"
+	Risk          string   `json:"risk"`
+	RequiredTools []string `json:"required_tools"`
+}
+
+// name of the function the model is forced to call with the command_response
+const command_response_function = "return_command"
+
+// JSON schema of command_response, used for function calling
+const command_response_schema = `{
+	"type": "object",
+	"properties": {
+		"command": {
+			"type": "string",
+			"description": "The shell command, ready to run. No markdown."
+		},
+		"description": {
+			"type": "string",
+			"description": "What the command does, in one short sentence."
+		},
+		"risk": {
+			"type": "string",
+			"enum": ["low", "medium", "high"],
+			"description": "high when the command deletes or overwrites data, changes the system or needs superuser privileges."
+		},
+		"required_tools": {
+			"type": "array",
+			"items": {"type": "string"},
+			"description": "The programs the command runs, eg: [\"find\", \"xargs\"]."
+		}
+	},
+	"required": ["command", "description", "risk", "required_tools"]
+}`
+
+/**
+* Parses the response of a command request. JSON responses are decoded,
+* even when wrapped in a code fence. Anything else is taken as the command
+* itself once the markdown code fences around it are stripped
+**/
+func parseCommandResponse(raw string) command_response {
+	text := stripCodeFences(raw)
+
+	if strings.HasPrefix(text, "{") || strings.Contains(text, `"command"`) {
+		start := strings.Index(text, "{")
+		end := strings.LastIndex(text, "}")
+
+		if start >= 0 && end > start {
+			var response command_response
+			err := json.Unmarshal([]byte(text[start:end+1]), &response)
+			if err == nil && strings.TrimSpace(response.Command) != "" {
+				response.Command = stripCodeFences(response.Command)
+				response.Description = strings.TrimSpace(response.Description)
+				response.Risk = strings.ToLower(strings.TrimSpace(response.Risk))
+				return response
+			}
+		}
+	}
+
+	return command_response{Command: text}
+}
+
+/**
+* Returns the command of a response that is still being streamed so it can
+* be rendered as it is produced, eg: `{"command": "ls -l` -> `ls -l`
+**/
+func partialCommand(raw string) string {
+	text := strings.TrimSpace(raw)
+	text = strings.TrimSpace(strings.TrimPrefix(text, "```json"))
+
+	if strings.HasPrefix(text, "{") {
+		return partialJSONString(text, "command")
+	}
+
+	return stripCodeFences(text)
+}
+
+/**
+* Returns the value decoded so far of a string field of an incomplete JSON object
+**/
+func partialJSONString(text string, field string) string {
+	key := strings.Index(text, strconv.Quote(field))
+	if key < 0 {
+		return ""
+	}
+
+	rest := strings.TrimLeft(text[key+len(field)+2:], " \t\r\n")
+	if !strings.HasPrefix(rest, ":") {
+		return ""
+	}
+
+	rest = strings.TrimLeft(rest[1:], " \t\r\n")
+	if !strings.HasPrefix(rest, `"`) {
+		return ""
+	}
+
+	var value strings.Builder
+	for i := 1; i < len(rest); i++ {
+		switch rest[i] {
+		case '"':
+			return value.String()
+
+		case '\\':
+			if i+1 >= len(rest) {
+				return value.String()
+			}
+			i++
+
+			switch rest[i] {
+			case 'n':
+				value.WriteByte('\n')
+			case 't':
+				value.WriteByte('\t')
+			case 'u':
+				if i+4 >= len(rest) {
+					return value.String()
+				}
+				unquoted, err := strconv.Unquote(`"\u` + rest[i+1:i+5] + `"`)
+				if err == nil {
+					value.WriteString(unquoted)
+				}
+				i += 4
+			default:
+				// \" \\ \/
+				value.WriteByte(rest[i])
+			}
+
+		default:
+			value.WriteByte(rest[i])
+		}
+	}
+
+	return value.String()
+}
+
+/**
+* Strips the markdown around a command: the first fenced code block,
+* inline code backticks and the "$ " prompt, eg: "```bash\n$ ls\n```" -> "ls"
+**/
+func stripCodeFences(text string) string {
+	text = strings.TrimSpace(text)
+
+	if start := strings.Index(text, "```"); start >= 0 {
+		block := text[start+3:]
+
+		if newline := strings.Index(block, "\n"); newline >= 0 {
+			// skip the language of the block, eg: ```bash
+			block = block[newline+1:]
+		} else if !strings.Contains(block, "```") {
+			// the language is still being streamed
+			block = ""
+		}
+
+		if end := strings.Index(block, "```"); end >= 0 {
+			block = block[:end]
+		}
+
+		text = strings.TrimSpace(block)
+	}
+
+	if len(text) > 1 && strings.HasPrefix(text, "`") && strings.HasSuffix(text, "`") {
+		text = strings.Trim(text, "`")
+	}
+
+	text = strings.TrimPrefix(text, "$ ")
+
+	return strings.TrimSpace(text)
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const config_file_location = "config.json"
+
+// app_config holds the user configs of the application.
+// It is loaded from config.json in the app config directory and
+// can be overridden with environment variables.
+type app_config struct {
+	// Provider is the LLM backend used to generate and explain commands.
+	// One of: "openai", "ollama", "fake"
+	Provider    string         `json:"provider"`
+	OpenAI      openai_config  `json:"openai"`
+	Ollama      ollama_config  `json:"ollama"`
+	Command     request_config `json:"command"`
+	Explanation request_config `json:"explanation"`
+	Context     context_config `json:"context"`
+	// Alternatives is the number of candidate commands requested when
+	// asking for alternatives
+	Alternatives int `json:"alternatives"`
+}
+
+type openai_config struct {
+	// BaseURL of an OpenAI compatible API, eg: an internal gateway
+	BaseURL string `json:"base_url"`
+}
+
+type ollama_config struct {
+	// BaseURL of the local server, eg: Ollama or llama.cpp
+	BaseURL string `json:"base_url"`
+}
+
+// context_config toggles the information about the machine that is added
+// to the command generation prompt so the commands fit it
+type context_config struct {
+	Shell          bool `json:"shell"`
+	Cwd            bool `json:"cwd"`
+	Git            bool `json:"git"`
+	Files          bool `json:"files"`
+	Distro         bool `json:"distro"`
+	PackageManager bool `json:"package_manager"`
+	// Binaries tells which programs named in the prompt are installed
+	Binaries bool `json:"binaries"`
+}
+
+// request_config holds the model parameters for one type of request
+// (command generation or explanation).
+// An empty Model or a zero Temperature or MaxTokens means the provider
+// default is used.
+type request_config struct {
+	Model       string  `json:"model"`
+	Temperature float32 `json:"temperature"`
+	MaxTokens   int     `json:"max_tokens"`
+	// Timeout of each attempt in seconds, 0 means no timeout
+	Timeout int `json:"timeout"`
+	// MaxRetries on rate limits, server errors and timeouts
+	MaxRetries int `json:"max_retries"`
+}
+
+func (req_cfg request_config) timeout() time.Duration {
+	return time.Duration(req_cfg.Timeout) * time.Second
+}
+
+func defaultConfig() app_config {
+	return app_config{
+		Provider: "openai",
+		OpenAI: openai_config{
+			BaseURL: "https://api.openai.com/v1",
+		},
+		Ollama: ollama_config{
+			BaseURL: "http://localhost:11434",
+		},
+		Command: request_config{
+			Timeout:    60,
+			MaxRetries: 3,
+		},
+		Explanation: request_config{
+			Timeout:    60,
+			MaxRetries: 3,
+		},
+		Alternatives: 3,
+		// the working directory and its files are opt-in, their names can
+		// be private and they would be sent to a remote provider
+		Context: context_config{
+			Shell:          true,
+			Git:            true,
+			Distro:         true,
+			PackageManager: true,
+			Binaries:       true,
+		},
+	}
+}
+
+/**
+* Returns the model used when none is set in the config for the provider
+**/
+func defaultModel(provider string) string {
+	switch provider {
+	case "ollama":
+		return "llama3"
+	default:
+		return "gpt-3.5-turbo"
+	}
+}
+
+/**
+* Loads config.json on top of the default config and then applies the
+* environment variable overrides
+**/
+func loadConfig() (app_config, error) {
+	cfg := defaultConfig()
+
+	file, err := os.Open(filepath.Join(getAppConfigDir(), config_file_location))
+	if err == nil {
+		defer file.Close()
+
+		err = json.NewDecoder(file).Decode(&cfg)
+		if err != nil {
+			return cfg, fmt.Errorf("error decoding %s: %w", config_file_location, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return cfg, fmt.Errorf("error loading %s: %w", config_file_location, err)
+	}
+
+	err = applyEnvOverrides(&cfg)
+	if err != nil {
+		return cfg, err
+	}
+
+	if cfg.Alternatives < 1 {
+		return cfg, fmt.Errorf("invalid alternatives %d: at least 1 command must be requested", cfg.Alternatives)
+	}
+
+	if cfg.Command.Model == "" {
+		cfg.Command.Model = defaultModel(cfg.Provider)
+	}
+	if cfg.Explanation.Model == "" {
+		cfg.Explanation.Model = defaultModel(cfg.Provider)
+	}
+
+	return cfg, nil
+}
+
+func applyEnvOverrides(cfg *app_config) error {
+	if value := os.Getenv("CLAI_PROVIDER"); value != "" {
+		cfg.Provider = value
+	}
+
+	if value := os.Getenv("OPENAI_BASE_URL"); value != "" {
+		cfg.OpenAI.BaseURL = value
+	}
+
+	if value := os.Getenv("OLLAMA_HOST"); value != "" {
+		// OLLAMA_HOST is usually set without the scheme, eg: 127.0.0.1:11434
+		if !strings.Contains(value, "://") {
+			value = "http://" + value
+		}
+		cfg.Ollama.BaseURL = value
+	}
+
+	if value := os.Getenv("CLAI_ALTERNATIVES"); value != "" {
+		alternatives, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid CLAI_ALTERNATIVES: %w", err)
+		}
+		cfg.Alternatives = alternatives
+	}
+
+	err := applyRequestEnvOverrides(&cfg.Command, "CLAI_COMMAND_")
+	if err != nil {
+		return err
+	}
+
+	return applyRequestEnvOverrides(&cfg.Explanation, "CLAI_EXPLANATION_")
+}
+
+func applyRequestEnvOverrides(req_cfg *request_config, prefix string) error {
+	if value := os.Getenv(prefix + "MODEL"); value != "" {
+		req_cfg.Model = value
+	}
+
+	if value := os.Getenv(prefix + "TEMPERATURE"); value != "" {
+		temperature, err := strconv.ParseFloat(value, 32)
+		if err != nil {
+			return fmt.Errorf("invalid %sTEMPERATURE: %w", prefix, err)
+		}
+		req_cfg.Temperature = float32(temperature)
+	}
+
+	if value := os.Getenv(prefix + "MAX_TOKENS"); value != "" {
+		max_tokens, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid %sMAX_TOKENS: %w", prefix, err)
+		}
+		req_cfg.MaxTokens = max_tokens
+	}
+
+	if value := os.Getenv(prefix + "TIMEOUT"); value != "" {
+		timeout, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid %sTIMEOUT: %w", prefix, err)
+		}
+		req_cfg.Timeout = timeout
+	}
+
+	if value := os.Getenv(prefix + "MAX_RETRIES"); value != "" {
+		max_retries, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid %sMAX_RETRIES: %w", prefix, err)
+		}
+		req_cfg.MaxRetries = max_retries
+	}
+
+	return nil
+}
+
+/**
+* Renders the shell context toggles as a markdown list
+**/
+func renderContextConfig(ctx_cfg context_config) string {
+	toggle := func(enabled bool) string {
+		if enabled {
+			return "✅"
+		}
+		return "❌"
+	}
+
+	return "- **Shell and version**: " + toggle(ctx_cfg.Shell) + "\n" +
+		"- **Working directory**: " + toggle(ctx_cfg.Cwd) + "\n" +
+		"- **Git status**: " + toggle(ctx_cfg.Git) + "\n" +
+		"- **Files in the working directory**: " + toggle(ctx_cfg.Files) + "\n" +
+		"- **Distro**: " + toggle(ctx_cfg.Distro) + "\n" +
+		"- **Package manager**: " + toggle(ctx_cfg.PackageManager) + "\n" +
+		"- **Installed programs named in the prompt**: " + toggle(ctx_cfg.Binaries) + "\n"
+}
+
+/**
+* Renders the effective values of a request config as a markdown list
+**/
+func renderRequestConfig(req_cfg request_config) string {
+	temperature := "provider default"
+	if req_cfg.Temperature != 0 {
+		temperature = strconv.FormatFloat(float64(req_cfg.Temperature), 'f', -1, 32)
+	}
+
+	max_tokens := "provider default"
+	if req_cfg.MaxTokens != 0 {
+		max_tokens = strconv.Itoa(req_cfg.MaxTokens)
+	}
+
+	timeout := "none"
+	if req_cfg.Timeout != 0 {
+		timeout = strconv.Itoa(req_cfg.Timeout) + "s"
+	}
+
+	return "- **Model**: `" + req_cfg.Model + "`\n" +
+		"- **Temperature**: " + temperature + "\n" +
+		"- **Max tokens**: " + max_tokens + "\n" +
+		"- **Timeout**: " + timeout + "\n" +
+		"- **Max retries**: " + strconv.Itoa(req_cfg.MaxRetries) + "\n"
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// file where the shell integration records the last command that failed
+const last_failed_command_file = "last_failed_command"
+
+// max bytes of stderr sent to the model when asking for a fix
+const fix_stderr_limit = 4000
+
+// failed_command is a command that exited with an error
+type failed_command struct {
+	command   string
+	exit_code int
+	// stderr is empty when the command was run by the shell instead of clAI
+	stderr string
+}
+
+func lastFailedCommandPath() string {
+	return filepath.Join(getAppConfigDir(), last_failed_command_file)
+}
+
+/**
+* Reads the last failed command recorded by the shell integration,
+* the file has the exit code on the first line and the command after it
+**/
+func loadLastFailedCommand() (failed_command, error) {
+	content, err := os.ReadFile(lastFailedCommandPath())
+	if os.IsNotExist(err) {
+		return failed_command{}, errors.New("no failed command was recorded, enable the shell integration first, eg: eval \"$(clai init bash)\"")
+	}
+	if err != nil {
+		return failed_command{}, err
+	}
+
+	lines := strings.SplitN(strings.TrimSpace(string(content)), "\n", 2)
+	if len(lines) != 2 {
+		return failed_command{}, fmt.Errorf("invalid %s file", last_failed_command_file)
+	}
+
+	exit_code, err := strconv.Atoi(strings.TrimSpace(lines[0]))
+	if err != nil {
+		return failed_command{}, fmt.Errorf("invalid %s file: %w", last_failed_command_file, err)
+	}
+
+	return failed_command{
+		command:   strings.TrimSpace(lines[1]),
+		exit_code: exit_code,
+	}, nil
+}
+
+/**
+* Follow-up prompt asking for a corrected version of the failed command.
+* Its first line is short because it is the one shown in the history
+**/
+func buildFixPrompt(failed failed_command) string {
+	prompt := fmt.Sprintf("Fix this command, it failed with exit code %d: %s\n", failed.exit_code, failed.command)
+
+	stderr := strings.TrimSpace(failed.stderr)
+	if len(stderr) > fix_stderr_limit {
+		stderr = stderr[len(stderr)-fix_stderr_limit:]
+	}
+
+	if stderr != "" {
+		prompt += "stderr:\n" + stderr + "\n"
+	} else {
+		prompt += "The error output is not available.\n"
+	}
+
+	return prompt + "Return the corrected command."
+}
+
+/**
+* Asks the model to fix the failed command, continuing the conversation
+* that generated it when there is one
+**/
+func startFixRequest(m model, failed failed_command) (tea.Model, tea.Cmd) {
+	m.prompt_screen_err = ""
+	m.selected_screen = "prompt_screen"
+
+	return startGPTcommandRequest(m, m.conversation, buildFixPrompt(failed))
+}
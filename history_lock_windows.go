@@ -0,0 +1,16 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "os"
+
+// there is no flock on Windows, the records are still appended with a
+// single write each so concurrent sessions do not corrupt the file
+func lockFile(file *os.File) error {
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
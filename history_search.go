@@ -0,0 +1,268 @@
+package main
+
+import (
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/sahilm/fuzzy"
+)
+
+// history_query is a search of the history screen,
+// eg: `docker cmd:prune dir:. tag:cleanup before:7d`
+type history_query struct {
+	// terms match the prompt, the command or the explanation
+	terms []string
+	// cmd: terms only match the command
+	commands []string
+	// dir: terms match the working directory, "." is the current one
+	dirs []string
+	// tag: terms match the tags exactly
+	tags   []string
+	before time.Time
+}
+
+/**
+* Parses the search typed in the history screen. Values with spaces are
+* quoted, eg: cmd:"git push". before: takes a date (2024-05-01) or an age
+* in days or weeks (7d, 2w)
+**/
+func parseHistoryQuery(text string) history_query {
+	var query history_query
+
+	for _, word := range splitHistoryQuery(text) {
+		field, value := "", word
+		if i := strings.Index(word, ":"); i > 0 {
+			field, value = word[:i], strings.Trim(word[i+1:], `"`)
+		}
+
+		switch field {
+		case "cmd":
+			query.commands = append(query.commands, strings.ToLower(value))
+
+		case "dir":
+			if value == "." {
+				value, _ = os.Getwd()
+			}
+			query.dirs = append(query.dirs, strings.ToLower(value))
+
+		case "tag":
+			query.tags = append(query.tags, strings.ToLower(strings.TrimPrefix(value, "#")))
+
+		case "before":
+			if before, ok := parseHistoryDate(value); ok {
+				query.before = before
+				continue
+			}
+			// not a date, it is searched as text
+			query.terms = append(query.terms, strings.ToLower(word))
+
+		default:
+			query.terms = append(query.terms, strings.ToLower(strings.Trim(word, `"`)))
+		}
+	}
+
+	return query
+}
+
+/**
+* Splits the search on the spaces that are not quoted
+**/
+func splitHistoryQuery(text string) []string {
+	var words []string
+	var word strings.Builder
+	in_quotes := false
+
+	for _, r := range text {
+		switch {
+		case r == '"':
+			in_quotes = !in_quotes
+			word.WriteRune(r)
+
+		case r == ' ' && !in_quotes:
+			if word.Len() > 0 {
+				words = append(words, word.String())
+				word.Reset()
+			}
+
+		default:
+			word.WriteRune(r)
+		}
+	}
+
+	if word.Len() > 0 {
+		words = append(words, word.String())
+	}
+
+	return words
+}
+
+func parseHistoryDate(value string) (time.Time, bool) {
+	if date, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return date, true
+	}
+
+	if len(value) < 2 {
+		return time.Time{}, false
+	}
+
+	amount, err := strconv.Atoi(value[:len(value)-1])
+	if err != nil || amount < 0 {
+		return time.Time{}, false
+	}
+
+	switch value[len(value)-1] {
+	case 'd':
+		return time.Now().AddDate(0, 0, -amount), true
+	case 'w':
+		return time.Now().AddDate(0, 0, -7*amount), true
+	}
+
+	return time.Time{}, false
+}
+
+/**
+* Returns how well the entry matches the query, 0 when it doesn't, and the
+* runes of the prompt that matched to highlight them
+**/
+func (q history_query) match(item history_list_item) (float64, []int) {
+	if !q.before.IsZero() && !item.CreatedAt.Before(q.before) {
+		return 0, nil
+	}
+
+	command := strings.ToLower(item.ResponseCode)
+	for _, c := range q.commands {
+		if !strings.Contains(command, c) {
+			return 0, nil
+		}
+	}
+
+	cwd := strings.ToLower(item.Cwd)
+	for _, dir := range q.dirs {
+		if !strings.Contains(cwd, dir) {
+			return 0, nil
+		}
+	}
+
+	for _, tag := range q.tags {
+		if !hasHistoryTag(item, tag) {
+			return 0, nil
+		}
+	}
+
+	score := 1.0
+	var matched []int
+
+	prompt := strings.ToLower(item.PromptText)
+	explanation := strings.ToLower(item.ResponseExplanation)
+
+	for _, term := range q.terms {
+		switch {
+		case strings.Contains(prompt, term):
+			start := utf8.RuneCountInString(prompt[:strings.Index(prompt, term)])
+			for i := 0; i < utf8.RuneCountInString(term); i++ {
+				matched = append(matched, start+i)
+			}
+			score += 3
+
+		case strings.Contains(command, term), hasHistoryTag(item, strings.TrimPrefix(term, "#")):
+			score += 3
+
+		case strings.Contains(explanation, term):
+			score += 1
+
+		default:
+			// typos and abbreviations, eg: "dkr prn" for "docker prune"
+			matches := fuzzy.Find(term, []string{prompt, command})
+			if len(matches) == 0 {
+				return 0, nil
+			}
+
+			if matches[0].Index == 0 {
+				matched = append(matched, matches[0].MatchedIndexes...)
+			}
+			score += 1
+		}
+	}
+
+	return score, matched
+}
+
+func hasHistoryTag(item history_list_item, tag string) bool {
+	for _, t := range item.Tags {
+		if strings.ToLower(t) == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+/**
+* Parses the tags typed in the history screen, eg: "docker, #cleanup" -> [docker cleanup]
+**/
+func parseHistoryTags(text string) []string {
+	var tags []string
+	seen := map[string]bool{}
+
+	for _, tag := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == ' ' }) {
+		tag = strings.TrimPrefix(tag, "#")
+		if tag == "" || seen[tag] {
+			continue
+		}
+
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+
+	return tags
+}
+
+/**
+* Filter of the history list: the entries that match the search, the most
+* recent and most used commands first. The items are the ones of the list
+**/
+func historyFilter(items []history_list_item) list.FilterFunc {
+	// how many times each command is in the history
+	uses := map[string]int{}
+	for _, item := range items {
+		uses[strings.Join(strings.Fields(item.ResponseCode), " ")]++
+	}
+
+	return func(text string, targets []string) []list.Rank {
+		query := parseHistoryQuery(text)
+
+		var ranks []list.Rank
+		scores := map[int]float64{}
+
+		for i := range targets {
+			if i >= len(items) {
+				break
+			}
+
+			score, matched := query.match(items[i])
+			if score == 0 {
+				continue
+			}
+
+			age_in_days := time.Since(items[i].CreatedAt).Hours() / 24
+			recency := 1 / (1 + math.Max(age_in_days, 0)/7)
+			frequency := 1 + math.Log(float64(uses[strings.Join(strings.Fields(items[i].ResponseCode), " ")]))
+
+			scores[i] = score * recency * frequency
+			ranks = append(ranks, list.Rank{Index: i, MatchedIndexes: matched})
+		}
+
+		// the items are the most recent first, which breaks the ties
+		sort.SliceStable(ranks, func(a, b int) bool {
+			return scores[ranks[a].Index] > scores[ranks[b].Index]
+		})
+
+		return ranks
+	}
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// file of the history, one JSON record per line
+const history_file_location = "history.jsonl"
+
+// HistoryStore persists the history entries
+type HistoryStore interface {
+	// List returns the entries, oldest first
+	List() ([]history_list_item, error)
+	// Append adds the entry, it is given an ID when it has none
+	Append(item history_list_item) error
+	// Update changes the entry with the ID, it does nothing when the entry does not exist anymore
+	Update(id string, update func(item *history_list_item)) error
+	// Delete removes the entry from the disk, not only from the list
+	Delete(id string) error
+	// Compact drops the previous versions of the entries from the disk
+	Compact() error
+	Clear() error
+}
+
+// history_record is a line of the JSONL history file. Entries are not
+// rewritten in place: an update appends the new version of the entry, the
+// file is only rewritten to remove data from it
+type history_record struct {
+	// Op is "add", "update" or "rewrite". The rewrite record is the first
+	// line of a rewritten file
+	Op string `json:"op"`
+	// Generation changes every time the file is rewritten
+	Generation string            `json:"generation,omitempty"`
+	Item       history_list_item `json:"item"`
+}
+
+/**
+* Returns a new ID for a history entry, it sorts by creation time
+**/
+func newHistoryID() string {
+	random := make([]byte, 4)
+	rand.Read(random)
+
+	return strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + hex.EncodeToString(random)
+}
+
+/**
+* jsonlHistoryStore is an append-only history. The entries are kept in
+* memory and only the lines appended since the last read are parsed, so
+* the file is only read or written as a whole when an entry is deleted
+**/
+type jsonlHistoryStore struct {
+	path        string
+	legacy_path string
+
+	mu     sync.Mutex
+	loaded bool
+	items  []history_list_item
+	// positions maps the IDs to their index in items
+	positions map[string]int
+	// offset is where the lines that were not read yet start
+	offset int64
+	// generation of the file that was read, see history_record
+	generation string
+}
+
+func newJSONLHistoryStore(path string, legacy_path string) *jsonlHistoryStore {
+	return &jsonlHistoryStore{
+		path:        path,
+		legacy_path: legacy_path,
+	}
+}
+
+var default_history_store HistoryStore
+var default_history_store_once sync.Once
+
+/**
+* Returns the history store in the app config dir
+**/
+func historyStore() HistoryStore {
+	default_history_store_once.Do(func() {
+		default_history_store = newJSONLHistoryStore(
+			filepath.Join(getAppConfigDir(), history_file_location),
+			filepath.Join(getAppConfigDir(), store_file_location),
+		)
+	})
+
+	return default_history_store
+}
+
+func (s *jsonlHistoryStore) List() ([]history_list_item, error) {
+	var items []history_list_item
+
+	err := s.locked(func(file *os.File) error {
+		items = make([]history_list_item, len(s.items))
+		copy(items, s.items)
+		return nil
+	})
+
+	return items, err
+}
+
+func (s *jsonlHistoryStore) Append(item history_list_item) error {
+	if item.ID == "" {
+		item.ID = newHistoryID()
+	}
+
+	return s.locked(func(file *os.File) error {
+		return s.write(file, history_record{Op: "add", Item: item})
+	})
+}
+
+func (s *jsonlHistoryStore) Update(id string, update func(item *history_list_item)) error {
+	return s.locked(func(file *os.File) error {
+		index, ok := s.positions[id]
+		if !ok {
+			return nil
+		}
+
+		item := s.items[index]
+		update(&item)
+		item.ID = id
+
+		return s.write(file, history_record{Op: "update", Item: item})
+	})
+}
+
+func (s *jsonlHistoryStore) Delete(id string) error {
+	return s.locked(func(file *os.File) error {
+		index, ok := s.positions[id]
+		if !ok {
+			return nil
+		}
+
+		items := append([]history_list_item{}, s.items[:index]...)
+		items = append(items, s.items[index+1:]...)
+
+		// appending a deletion would leave the entry in the file, eg: a secret in the command
+		return s.rewrite(items)
+	})
+}
+
+func (s *jsonlHistoryStore) Compact() error {
+	return s.locked(func(file *os.File) error {
+		return s.rewrite(s.items)
+	})
+}
+
+func (s *jsonlHistoryStore) Clear() error {
+	return s.locked(func(file *os.File) error {
+		// rewritten instead of removed or truncated so the other sessions notice it
+		if err := s.rewrite(nil); err != nil {
+			return err
+		}
+
+		// the legacy store would be migrated again otherwise, and its backup
+		// still holds the old history
+		for _, path := range []string{s.legacy_path, s.legacy_path + ".bak"} {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+/**
+* Runs the change with the history file locked so the concurrent clAI
+* sessions apply their changes one after the other, on the latest entries
+**/
+func (s *jsonlHistoryStore) locked(change func(file *os.File) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := s.openLocked()
+	if err != nil {
+		return err
+	}
+
+	// migrated with the file locked so only one session does it
+	if !s.loaded {
+		migrated, err := s.migrateLegacyStore(file)
+		if err != nil {
+			unlockFile(file)
+			file.Close()
+			return err
+		}
+		s.loaded = true
+
+		// the migration replaced the locked file
+		if migrated {
+			unlockFile(file)
+			file.Close()
+			if file, err = s.openLocked(); err != nil {
+				return err
+			}
+		}
+	}
+	defer file.Close()
+	defer unlockFile(file)
+
+	if err := s.refresh(); err != nil {
+		return err
+	}
+
+	return change(file)
+}
+
+/**
+* Opens the history file and waits for its lock. The file may be replaced by
+* another session while waiting, then the new one is locked instead
+**/
+func (s *jsonlHistoryStore) openLocked() (*os.File, error) {
+	for {
+		file, err := os.OpenFile(s.path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := lockFile(file); err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		locked_info, err := file.Stat()
+		if err != nil {
+			unlockFile(file)
+			file.Close()
+			return nil, err
+		}
+
+		current_info, err := os.Stat(s.path)
+		if err == nil && os.SameFile(locked_info, current_info) {
+			return file, nil
+		}
+
+		unlockFile(file)
+		file.Close()
+	}
+}
+
+/**
+* Replaces the history file with one holding only the current version of
+* the entries. The new file is written next to it and renamed so the
+* history is never left half written
+**/
+func (s *jsonlHistoryStore) rewrite(items []history_list_item) error {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	if err := encoder.Encode(history_record{Op: "rewrite", Generation: newHistoryID()}); err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := encoder.Encode(history_record{Op: "add", Item: item}); err != nil {
+			return err
+		}
+	}
+
+	tmp_path := s.path + ".tmp"
+	tmp_file, err := os.OpenFile(tmp_path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp_file.Write(buffer.Bytes())
+	if err == nil {
+		err = tmp_file.Sync()
+	}
+	if close_err := tmp_file.Close(); err == nil {
+		err = close_err
+	}
+	if err != nil {
+		os.Remove(tmp_path)
+		return err
+	}
+
+	if err := os.Rename(tmp_path, s.path); err != nil {
+		os.Remove(tmp_path)
+		return err
+	}
+
+	return s.refresh()
+}
+
+/**
+* Reads the records appended since the last call, by this process or by
+* another clAI session
+**/
+func (s *jsonlHistoryStore) refresh() error {
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		s.reset()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	// the file was rewritten by another session
+	generation := readHistoryGeneration(file)
+	if stat.Size() < s.offset || generation != s.generation {
+		s.reset()
+		s.generation = generation
+	}
+
+	if _, err := file.Seek(s.offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// an incomplete last line is a write that is still in progress or
+			// that was interrupted, it is read again next time
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		s.offset += int64(len(line))
+		s.apply(line)
+	}
+}
+
+func (s *jsonlHistoryStore) reset() {
+	s.items = nil
+	s.positions = map[string]int{}
+	s.offset = 0
+	s.generation = ""
+}
+
+/**
+* Returns the generation in the first line of the file, an empty string
+* when the file was never rewritten
+**/
+func readHistoryGeneration(file *os.File) string {
+	first_line, _ := bufio.NewReader(io.NewSectionReader(file, 0, 512)).ReadBytes('\n')
+
+	var record history_record
+	if err := json.Unmarshal(first_line, &record); err != nil || record.Op != "rewrite" {
+		return ""
+	}
+
+	return record.Generation
+}
+
+func (s *jsonlHistoryStore) apply(line []byte) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return
+	}
+
+	var record history_record
+	if err := json.Unmarshal(line, &record); err != nil {
+		// skip the corrupted lines instead of losing the whole history
+		return
+	}
+
+	if s.positions == nil {
+		s.positions = map[string]int{}
+	}
+
+	switch record.Op {
+	case "add":
+		s.positions[record.Item.ID] = len(s.items)
+		s.items = append(s.items, record.Item)
+
+	case "update":
+		if index, ok := s.positions[record.Item.ID]; ok {
+			s.items[index] = record.Item
+		}
+	}
+}
+
+/**
+* Appends the record to the locked file with a single write
+**/
+func (s *jsonlHistoryStore) write(file *os.File, record history_record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	// terminate the line of an interrupted write so the record is not glued to it
+	if stat.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, stat.Size()-1); err == nil && last[0] != '\n' {
+			line = append([]byte("\n"), line...)
+		}
+	}
+	line = append(line, '\n')
+
+	if _, err := file.Write(line); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+
+	return s.refresh()
+}
+
+/**
+* Converts the store.json of the previous versions into the JSONL history
+* when the locked history file is still empty. Returns true when the file was
+* replaced, the old store is kept as store.json.bak
+**/
+func (s *jsonlHistoryStore) migrateLegacyStore(file *os.File) (bool, error) {
+	stat, err := file.Stat()
+	if err != nil || stat.Size() > 0 {
+		return false, err
+	}
+
+	content, err := os.ReadFile(s.legacy_path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var legacy_items []history_list_item
+	if err := json.Unmarshal(content, &legacy_items); err != nil && len(bytes.TrimSpace(content)) > 0 {
+		return false, fmt.Errorf("migrating %s: %w", store_file_location, err)
+	}
+
+	for i := range legacy_items {
+		legacy_items[i].ID = newHistoryID()
+	}
+
+	// an interrupted migration leaves the history empty and is started over
+	if err := s.rewrite(legacy_items); err != nil {
+		return false, err
+	}
+
+	if err := os.Rename(s.legacy_path, s.legacy_path+".bak"); err != nil && !os.IsNotExist(err) {
+		return true, err
+	}
+
+	return true, nil
+}
@@ -3,16 +3,15 @@ package main
 import (
 	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"runtime"
 	"strconv"
 	"strings"
-	"text/template"
 	"time"
 
 	"github.com/charmbracelet/bubbles/help"
@@ -25,23 +24,73 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/glamour"
 	"github.com/charmbracelet/lipgloss"
-	"github.com/sashabaranov/go-openai"
 )
 
 func main() {
 
 	configsFlag := flag.Bool("configs", false, "User configs of the application")
 	clearStoreFlag := flag.Bool("clear-store", false, "Clear the history store")
+	explainFlag := flag.Bool("explain", false, "One-shot mode: also print the explanation of the command")
+	runFlag := flag.Bool("run", false, "One-shot mode: run the command after generating it")
+	jsonFlag := flag.Bool("json", false, "One-shot mode: print the result as JSON")
+	forceFlag := flag.Bool("force", false, "One-shot mode: run the command even if it looks dangerous")
+	shellOutputFlag := flag.String("shell-output", "", "Used by the shell integration: file where the accepted command is written")
 	// openStoreFileFlag := flag.Bool("open-store-file", false, "Open the history store file in the default editor")
 	flag.Parse()
 
+	// clai init bash|zsh|fish, before loading the configs: the output is
+	// evaluated by the shell rc file so nothing else may be printed to stdout
+	if flag.NArg() > 0 && flag.Arg(0) == "init" {
+		if flag.NArg() != 2 {
+			fmt.Fprintln(os.Stderr, "❌ Usage: clai init bash|zsh|fish")
+			os.Exit(1)
+		}
+
+		script, err := shellInitScript(flag.Arg(1))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "❌ "+err.Error())
+			os.Exit(1)
+		}
+
+		fmt.Print(script)
+		os.Exit(0)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Printf("Error loading configs: %v\n", err)
+		os.Exit(1)
+	}
+
 	if *configsFlag {
 
-		is_open_ai_key_set := ""
-		if len(os.Getenv("OPENAI_API_KEY")) > 0 {
-			is_open_ai_key_set = "✅"
-		} else {
-			is_open_ai_key_set = "❌"
+		provider_status := ""
+		switch cfg.Provider {
+		case "ollama":
+			is_server_reachable := ""
+			if err := checkOllamaServer(cfg.Ollama.BaseURL); err == nil {
+				is_server_reachable = "✅"
+			} else {
+				is_server_reachable = "❌ " + err.Error()
+			}
+
+			provider_status = `**Local server base URL**: ` + "`" + cfg.Ollama.BaseURL + "`" + `
+
+---
+**Local server is reachable?**: ` + is_server_reachable
+
+		default:
+			is_open_ai_key_set := ""
+			if len(os.Getenv("OPENAI_API_KEY")) > 0 {
+				is_open_ai_key_set = "✅"
+			} else {
+				is_open_ai_key_set = "❌"
+			}
+
+			provider_status = `**OpenAI base URL**: ` + "`" + cfg.OpenAI.BaseURL + "`" + `
+
+---
+**OpenAI API key is set?**: ` + is_open_ai_key_set
 		}
 
 		renderer, _ := glamour.NewTermRenderer(
@@ -57,7 +106,28 @@ func main() {
 **App config directory**: ` + "`" + getAppConfigDir() + "`" + `
 	
 ---
-**OpenAI API key is set?**: ` + is_open_ai_key_set + `
+**Provider**: ` + "`" + cfg.Provider + "`" + `
+
+---
+` + provider_status + `
+
+---
+**Command requests**
+
+` + renderRequestConfig(cfg.Command) + `
+
+---
+**Alternatives**: ` + strconv.Itoa(cfg.Alternatives) + `
+
+---
+**Explanation requests**
+
+` + renderRequestConfig(cfg.Explanation) + `
+
+---
+**Shell context sent with the prompt**
+
+` + renderContextConfig(cfg.Context) + `
 
 ---
 `
@@ -79,9 +149,9 @@ func main() {
 			os.Exit(0)
 		}
 
-		err := os.Remove(filepath.Join(getAppConfigDir(), store_file_location))
+		err := historyStore().Clear()
 		if err != nil {
-			fmt.Printf("Error clearing history file: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Error clearing history file: %v\n", err)
 			os.Exit(1)
 		}
 		fmt.Println("✅ History store cleared")
@@ -100,7 +170,87 @@ func main() {
 	// 	os.Exit(0)
 	// }
 
-	p := tea.NewProgram(initialModel(), tea.WithAltScreen())
+	provider, err := newProvider(cfg)
+	if err != nil {
+		fmt.Printf("Error creating provider: %v\n", err)
+		os.Exit(1)
+	}
+
+	// clai fix, for the last failed command recorded by the shell integration
+	var failed failed_command
+	is_fix_subcommand := flag.NArg() == 1 && flag.Arg(0) == "fix"
+	if is_fix_subcommand {
+		failed, err = loadLastFailedCommand()
+		if err != nil {
+			fmt.Println("❌ " + err.Error())
+			os.Exit(1)
+		}
+	}
+
+	// clai explain '<command>', without a command the TUI opens on the explain screen
+	is_explain_subcommand := flag.NArg() > 0 && flag.Arg(0) == "explain"
+	if is_explain_subcommand {
+		command := strings.Join(flag.Args()[1:], " ")
+
+		if command == "" && isStdinPiped() {
+			stdin, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				fmt.Printf("Error reading stdin: %v\n", err)
+				os.Exit(1)
+			}
+			command = string(stdin)
+		}
+
+		if strings.TrimSpace(command) != "" {
+			os.Exit(runExplain(cfg, provider, command, *jsonFlag))
+		}
+	}
+
+	// one-shot mode: the prompt comes from the arguments or stdin and we skip the TUI
+	if !is_explain_subcommand && !is_fix_subcommand && (flag.NArg() > 0 || isStdinPiped()) {
+		prompt := strings.Join(flag.Args(), " ")
+
+		opts := one_shot_options{
+			explain:     *explainFlag,
+			run:         *runFlag,
+			json_output: *jsonFlag,
+			force:       *forceFlag,
+		}
+
+		if prompt == "" {
+			stdin, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				fmt.Printf("Error reading stdin: %v\n", err)
+				os.Exit(1)
+			}
+			prompt = string(stdin)
+		} else if isStdinPiped() {
+			// the piped data is what the command works on, eg: cat data.csv | clai "sum the 3rd column"
+			var err error
+			opts.stdin_sample, opts.stdin, err = readStdinSample(os.Stdin)
+			if err != nil {
+				fmt.Printf("Error reading stdin: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		os.Exit(runOneShot(cfg, provider, prompt, opts))
+	}
+
+	m := initialModel(cfg, provider)
+	m.shell_output_file = *shellOutputFlag
+
+	if is_explain_subcommand {
+		m.selected_screen = "explain_input_screen"
+	}
+
+	if is_fix_subcommand {
+		fix_model, fix_cmd := startFixRequest(m, failed)
+		m = fix_model.(model)
+		m.startup_cmd = fix_cmd
+	}
+
+	p := tea.NewProgram(m, tea.WithAltScreen())
 	if _, err := p.Run(); err != nil {
 		fmt.Printf("Alas, there's been an error: %v", err)
 		os.Exit(1)
@@ -108,6 +258,12 @@ func main() {
 
 	// when returning the command we need to output to the user screen
 	output := <-outputCh
+
+	// the shell integration redraws the command line, no need to output anything
+	if m.shell_output_file != "" {
+		return
+	}
+
 	fmt.Println(output)
 }
 
@@ -121,6 +277,9 @@ type model struct {
 	selected_screen                   string
 	prompt_response_screen_err        string
 	response_code_text                string // chatGPT response to the prompt as markdown code
+	response_raw_text                 string // the response as streamed, before parsing
+	response_description              string
+	response_risk                     string
 	response_code_viewport            viewport.Model
 	response_code_textInput           textinput.Model
 	running_command_screen_err        string
@@ -131,34 +290,124 @@ type model struct {
 	history_list                      list.Model
 	terminal_width                    int
 	terminal_height                   int
+	provider                          Provider
+	command_stream                    completion_stream
+	explanation_stream                completion_stream
+	cancel_command_request            context.CancelFunc
+	cancel_explanation_request        context.CancelFunc
+	command_request_id                int
+	command_retry_attempt             int
+	explanation_request_id            int
+	explanation_retry_attempt         int
+	config                            app_config
+	shell_output_file                 string // set by the shell integration to receive the accepted command
+	last_run                          run_result
+	run_output_viewport               viewport.Model
+	confirm_run_textInput             textinput.Model
+	confirm_run_screen_err            string
+	is_dry_running                    bool
+	dry_run_screen_err                string
+	dry_run_viewport                  viewport.Model
+	last_dry_run                      dry_run_result
+	command_request_prompt            string
+	command_request_history           []chat_message
+	conversation                      []chat_message
+	is_refining                       bool
+	refine_previous_prompt            string
+	alternatives_list                 list.Model
+	is_loading_alternatives           bool
+	alternatives_request_id           int
+	cancel_alternatives_request       context.CancelFunc
+	alternatives_screen_err           string
+	explain_textInput                 textinput.Model
+	explain_screen_err                string
+	history_edit_textInput            textinput.Model
+	history_edit_field                string // "tags" or "command"
+	history_edit_item                 history_list_item
+	history_edit_screen_err           string
+	history_delete_id                 string // entry waiting for the delete confirmation
+	is_explaining_pasted_command      bool
+	startup_cmd                       tea.Cmd // eg: the request of clai fix
+	missing_tools                     []string
+	show_install_command              bool
+	history_id                        string // history entry of the response, the explanation and run result are saved in it
+	history_store_err                 string
 }
 
+// history file of the previous versions, it is migrated to history.jsonl
 const store_file_location = "store.json"
 
+const prompt_placeholder = "How to..."
+
 // for json umarshall(decode) to work we need to have the fields exported
 // ie, start with a capital letter and also need to tell which fields to use
 // in the json with the `json:"field_name"` syntax
 type history_list_item struct {
+	ID                  string    `json:"id"`
 	CreatedAt           time.Time `json:"created_at"`
 	PromptText          string    `json:"prompt_text"`
 	ResponseCode        string    `json:"response_code"`
 	ResponseExplanation string    `json:"response_explanation"`
+	// the outputs of LastRun are truncated to history_output_limit
+	LastRun *run_result `json:"last_run,omitempty"`
+
+	// where and how the entry was made, filled by appendToHistory
+	Cwd      string `json:"cwd,omitempty"`
+	Hostname string `json:"hostname,omitempty"`
+	Shell    string `json:"shell,omitempty"`
+
+	Model   string  `json:"model,omitempty"`
+	Latency float64 `json:"latency,omitempty"` // seconds to generate the command
+
+	// what was done with the command
+	Ran    bool `json:"ran,omitempty"`
+	Copied bool `json:"copied,omitempty"`
+	Edited bool `json:"edited,omitempty"`
+	// OriginalCode is the generated command when it was edited before running it
+	OriginalCode string `json:"original_code,omitempty"`
+
+	// pinned entries are listed first in the history screen
+	Pinned bool     `json:"pinned,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
 }
 
 func (i history_list_item) Title() string       { return i.PromptText }
-func (i history_list_item) Description() string { return "" }
 func (i history_list_item) FilterValue() string { return i.PromptText }
 
+/**
+* The command along with the pin and the tags, eg: "📌 #docker · docker image prune"
+**/
+func (i history_list_item) Description() string {
+	description := i.ResponseCode
+
+	if len(i.Tags) > 0 {
+		description = "#" + strings.Join(i.Tags, " #") + " · " + description
+	}
+
+	if i.Pinned {
+		description = "📌 " + description
+	}
+
+	return description
+}
+
 var history_list_style = lipgloss.NewStyle().Margin(1, 2)
 var screen_style = lipgloss.NewStyle().Margin(1, 2)
+var warning_style = lipgloss.NewStyle().Foreground(lipgloss.Color("196")).Bold(true)
 
-func initialModel() model {
+func initialModel(cfg app_config, provider Provider) model {
 	prompt_textarea := textarea.New()
 	prompt_textarea.ShowLineNumbers = false
 	prompt_textarea.SetWidth(60)
-	prompt_textarea.Placeholder = "How to..."
+	prompt_textarea.Placeholder = prompt_placeholder
 	prompt_textarea.Focus()
 
+	explain_textInput := textinput.New()
+	explain_textInput.Placeholder = "tar -xzvf archive.tar.gz -C /tmp"
+	explain_textInput.CharLimit = 0
+	explain_textInput.Width = 70
+	explain_textInput.Focus()
+
 	response_code_textInput := textinput.New()
 	response_code_textInput.Focus()
 	response_code_textInput.CharLimit = 156
@@ -176,11 +425,45 @@ func initialModel() model {
 		BorderStyle(lipgloss.RoundedBorder()).
 		BorderForeground(lipgloss.Color(code_blocks_border_color))
 
+	confirm_run_textInput := textinput.New()
+	confirm_run_textInput.Focus()
+	confirm_run_textInput.CharLimit = 3
+	confirm_run_textInput.Placeholder = "yes"
+
+	dry_run_viewport := viewport.New(78, 12)
+	dry_run_viewport.Style = lipgloss.NewStyle().
+		BorderStyle(lipgloss.RoundedBorder()).
+		BorderForeground(lipgloss.Color(code_blocks_border_color))
+
+	run_output_viewport := viewport.New(78, 12)
+	run_output_viewport.Style = lipgloss.NewStyle().
+		BorderStyle(lipgloss.RoundedBorder()).
+		BorderForeground(lipgloss.Color(code_blocks_border_color))
+
 	loading_spinner := spinner.New()
 	loading_spinner.Spinner = spinner.Moon
 
 	history_list := list.New([]list.Item{}, list.NewDefaultDelegate(), 0, 0)
 	history_list.Title = "Your past queries"
+	history_list.FilterInput.Placeholder = `words cmd:git dir:. tag:docker before:7d`
+	history_list.AdditionalShortHelpKeys = func() []key.Binding {
+		return []key.Binding{
+			key.NewBinding(key.WithKeys("p"), key.WithHelp("p", "pin")),
+			key.NewBinding(key.WithKeys("t"), key.WithHelp("t", "tags")),
+			key.NewBinding(key.WithKeys("e"), key.WithHelp("e", "edit")),
+			key.NewBinding(key.WithKeys("x"), key.WithHelp("x", "delete")),
+		}
+	}
+	history_list.AdditionalFullHelpKeys = history_list.AdditionalShortHelpKeys
+
+	history_edit_textInput := textinput.New()
+	history_edit_textInput.CharLimit = 0
+	history_edit_textInput.Width = 70
+
+	alternatives_list := list.New([]list.Item{}, list.NewDefaultDelegate(), 0, 0)
+	alternatives_list.Title = "Alternatives (enter to choose, esc to go back)"
+	// q would quit without going through the output channel
+	alternatives_list.KeyMap.Quit.SetEnabled(false)
 
 	return model{
 		loading_spinner:                   loading_spinner,
@@ -196,8 +479,16 @@ func initialModel() model {
 		explanation_result_viewport:       explanation_result_viewport,
 		is_making_gpt_explanation_request: false,
 		running_command_screen_err:        "",
+		run_output_viewport:               run_output_viewport,
+		confirm_run_textInput:             confirm_run_textInput,
+		dry_run_viewport:                  dry_run_viewport,
 		history_list:                      history_list,
+		history_edit_textInput:            history_edit_textInput,
+		alternatives_list:                 alternatives_list,
+		explain_textInput:                 explain_textInput,
 		help:                              help.New(),
+		provider:                          provider,
+		config:                            cfg,
 	}
 }
 
@@ -207,6 +498,7 @@ func (m model) Init() tea.Cmd {
 		textarea.Blink,
 		m.loading_spinner.Tick,
 		initAppConfigDir,
+		m.startup_cmd,
 	)
 }
 
@@ -222,6 +514,18 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, tea.Sequence(tea.Quit, sendOutputToChannel("Bye!"))
 		}
 
+		// the error stays until the next key
+		m.history_store_err = ""
+
+	case historyStoreError:
+		// the history list fills the screen, it has its own place for messages
+		if m.selected_screen == "history_screen" {
+			return m, m.history_list.NewStatusMessage("❌ " + msg.err.Error())
+		}
+
+		m.history_store_err = "❌ " + msg.err.Error()
+		return m, nil
+
 	case spinner.TickMsg:
 		var cmd tea.Cmd
 		m.loading_spinner, cmd = m.loading_spinner.Update(msg)
@@ -250,21 +554,58 @@ func updateSelectedScreen(msg tea.Msg, m model) (tea.Model, tea.Cmd) {
 
 			case "ctrl+s":
 
+				if m.is_making_gpt_code_request {
+					return m, nil
+				}
+
 				if m.prompt_textarea.Value() == "" {
 					m.prompt_screen_err = "❌ Prompt cannot be empty"
 					return m, nil
 				}
 
-				m.loading_timer = time.Now()
-				m.is_making_gpt_code_request = true
+				// a refinement continues the conversation of the current command
+				if m.is_refining {
+					return startGPTcommandRequest(m, m.conversation, m.prompt_textarea.Value())
+				}
+
+				return startGPTcommandRequest(m, nil, m.prompt_textarea.Value())
+
+			case "esc":
+				if m.is_making_gpt_code_request {
+					m.cancel_command_request()
+					m.cancel_command_request = nil
+					m.is_making_gpt_code_request = false
+					m.loading_duration = time.Since(m.loading_timer).Seconds()
+					return m, nil
+				}
 
-				return m, makeGPTcommandRequest(m.prompt_textarea.Value())
+				// give up refining and go back to the current command
+				if m.is_refining {
+					m = stopRefining(m)
+					m.prompt_screen_err = ""
+					m.selected_screen = "prompt_response_screen"
+				}
+				return m, nil
 
 			case "ctrl+h":
+				// the response of the request would arrive on the history screen
+				if m.is_making_gpt_code_request || m.is_refining {
+					return m, nil
+				}
 
 				m.selected_screen = "history_screen"
 				return m, loadHistoryFromFile
 
+			case "ctrl+x":
+				if m.is_making_gpt_code_request || m.is_refining {
+					return m, nil
+				}
+
+				m.explain_textInput.SetValue("")
+				m.explain_screen_err = ""
+				m.selected_screen = "explain_input_screen"
+				return m, textinput.Blink
+
 			default:
 				if !m.prompt_textarea.Focused() {
 					cmd = m.prompt_textarea.Focus()
@@ -273,30 +614,66 @@ func updateSelectedScreen(msg tea.Msg, m model) (tea.Model, tea.Cmd) {
 				m.prompt_screen_err = ""
 			}
 
-		case GPTcommandResult:
+		case GPTcommandStream:
 
-			m.loading_duration = time.Since(m.loading_timer).Seconds()
+			// the request was cancelled while waiting for the stream
+			if !m.is_making_gpt_code_request {
+				msg.stream.Close()
+				return m, nil
+			}
 
-			m.response_code_text = msg.content
+			if m.is_refining {
+				m = stopRefining(m)
+			}
 
-			m.response_code_viewport.SetContent(renderResponseCodeViewport(m.response_code_text))
+			// the response is streamed into the response screen as it is produced
+			m.command_stream = msg.stream
+			m.response_raw_text = ""
+			m.response_code_text = ""
+			m.response_description = ""
+			m.response_risk = ""
+			m.command_explanation_text = ""
+			m.prompt_response_screen_err = ""
+			m.response_code_viewport.SetContent("")
+			m.explanation_result_viewport.SetContent("")
 
 			m.selected_screen = "prompt_response_screen"
 
-			m.is_making_gpt_code_request = false
-
-			return m, appendToHistory(
-				history_list_item{
-					PromptText:   m.prompt_textarea.Value(),
-					ResponseCode: m.response_code_text,
-				},
-			)
+			return m, waitForGPTcommandChunk(msg.stream)
 
 		case GPTcommandError:
+			if !m.is_making_gpt_code_request || errors.Is(msg.err, context.Canceled) {
+				return m, nil
+			}
+
+			m.cancel_command_request()
+
+			if isRetryableRequestError(msg.err) && m.command_retry_attempt < m.config.Command.MaxRetries {
+				m.command_retry_attempt++
+				request_id := m.command_request_id
+
+				return m, tea.Tick(retryBackoff(m.command_retry_attempt), func(time.Time) tea.Msg {
+					return retryGPTcommandRequest{request_id: request_id}
+				})
+			}
+
 			m.loading_duration = time.Since(m.loading_timer).Seconds()
+			m.cancel_command_request = nil
 			m.is_making_gpt_code_request = false
-			m.prompt_screen_err = "❌ " + msg.err.Error()
+			m.command_retry_attempt = 0
+			m.prompt_screen_err = "❌ " + friendlyRequestError(msg.err)
 			return m, nil
+
+		case retryGPTcommandRequest:
+			// the request was cancelled or replaced while waiting to retry
+			if !m.is_making_gpt_code_request || msg.request_id != m.command_request_id {
+				return m, nil
+			}
+
+			ctx, cancel := newRequestContext(m.config.Command)
+			m.cancel_command_request = cancel
+
+			return m, makeGPTcommandRequest(ctx, m.provider, m.command_request_history, m.command_request_prompt)
 		}
 
 		m.prompt_textarea, cmd = m.prompt_textarea.Update(msg)
@@ -309,20 +686,59 @@ func updateSelectedScreen(msg tea.Msg, m model) (tea.Model, tea.Cmd) {
 		switch msg := msg.(type) {
 
 		case tea.KeyMsg:
+
+			// while streaming, the response is incomplete so only allow to cancel it
+			if m.is_making_gpt_code_request || m.is_making_gpt_explanation_request {
+				switch msg.String() {
+				case "esc":
+					if m.is_making_gpt_code_request {
+						m.cancel_command_request()
+						m.cancel_command_request = nil
+						m.command_stream = nil
+						m.is_making_gpt_code_request = false
+
+						m.prompt_textarea.Focus()
+						m.response_code_text = ""
+						m.command_explanation_text = ""
+						m.prompt_response_screen_err = ""
+						m.selected_screen = "prompt_screen"
+						return m, textarea.Blink
+					}
+
+					// keep the explanation we got so far
+					m.cancel_explanation_request()
+					m.cancel_explanation_request = nil
+					m.explanation_stream = nil
+					m.is_making_gpt_explanation_request = false
+					m.loading_duration = time.Since(m.loading_timer).Seconds()
+					return m, nil
+
+				default:
+					m.explanation_result_viewport, cmd = m.explanation_result_viewport.Update(msg)
+					return m, cmd
+				}
+			}
+
 			switch msg.String() {
 
 			case "enter":
-				m.loading_timer = time.Now()
-				m.selected_screen = "running_command_screen"
-
-				return m, runOnTerminal(m.response_code_text)
+				return runResponseCode(m)
 
-			case "e":
+			case "d":
+				// the shell integration doesn't run commands
+				if m.shell_output_file != "" {
+					return m, nil
+				}
 
 				m.loading_timer = time.Now()
-				m.is_making_gpt_explanation_request = true
+				m.is_dry_running = true
+				m.dry_run_screen_err = ""
+				m.selected_screen = "dry_run_screen"
 
-				return m, makeGPTexplanationRequest(m.response_code_text)
+				return m, dryRunCommand(m.response_code_text)
+
+			case "e":
+				return startGPTexplanationRequest(m)
 
 			case "esc":
 				m.prompt_textarea.Focus()
@@ -337,6 +753,56 @@ func updateSelectedScreen(msg tea.Msg, m model) (tea.Model, tea.Cmd) {
 				m.selected_screen = "response_edit_screen"
 				return m, nil
 
+			case "r":
+				if len(m.conversation) == 0 {
+					return m, nil
+				}
+
+				// the follow-up is written in the prompt screen, the original prompt is restored after
+				m.refine_previous_prompt = m.prompt_textarea.Value()
+				m.prompt_textarea.SetValue("")
+				m.prompt_textarea.Placeholder = "Refine the command, eg: but only for .png files"
+				m.prompt_textarea.Focus()
+				m.prompt_screen_err = ""
+				m.is_refining = true
+				m.selected_screen = "prompt_screen"
+				return m, textarea.Blink
+
+			case "n":
+				if len(m.conversation) < 2 {
+					return m, nil
+				}
+
+				m.loading_timer = time.Now()
+				m.is_loading_alternatives = true
+				m.alternatives_screen_err = ""
+				m.alternatives_request_id++
+				m.selected_screen = "alternatives_screen"
+
+				ctx, cancel := newRequestContext(m.config.Command)
+				m.cancel_alternatives_request = cancel
+
+				// alternatives for the last prompt of the conversation
+				last_prompt := len(m.conversation) - 2
+				return m, makeGPTalternativesRequest(ctx, m.provider, m.conversation[:last_prompt], m.conversation[last_prompt].Content, m.config.Alternatives, m.alternatives_request_id)
+
+			case "a":
+				if len(m.missing_tools) == 0 || len(m.conversation) == 0 {
+					return m, nil
+				}
+
+				// the new command is generated from the prompt screen like any other
+				m.selected_screen = "prompt_screen"
+				return startGPTcommandRequest(m, m.conversation, installedToolsOnlyPrompt(m.missing_tools))
+
+			case "i":
+				if len(m.missing_tools) == 0 {
+					return m, nil
+				}
+
+				m.show_install_command = !m.show_install_command
+				return m, nil
+
 			case "c":
 				return m, copyCommandToClipboard(m.response_code_text)
 
@@ -346,28 +812,180 @@ func updateSelectedScreen(msg tea.Msg, m model) (tea.Model, tea.Cmd) {
 
 			}
 
-		case GPTexplanationResult:
+		case GPTcommandChunk:
+			if msg.stream != m.command_stream {
+				return m, nil
+			}
+
+			// the response is a JSON object, only its command is rendered while streaming
+			m.response_raw_text += msg.content
+			m.response_code_text = partialCommand(m.response_raw_text)
+
+			m.response_code_viewport.SetContent(renderResponseCodeViewport(m.response_code_text))
+
+			return m, waitForGPTcommandChunk(msg.stream)
+
+		case GPTcommandResult:
+			if msg.stream != m.command_stream {
+				return m, nil
+			}
+
+			m.loading_duration = time.Since(m.loading_timer).Seconds()
+
+			m.cancel_command_request()
+			m.cancel_command_request = nil
+			m.command_stream = nil
+			m.is_making_gpt_code_request = false
+
+			m = setResponse(m, parseCommandResponse(m.response_raw_text))
+			if m.response_code_text == "" {
+				m.prompt_response_screen_err = "❌ The response has no command"
+				return m, nil
+			}
+
+			conversation := append([]chat_message{}, m.command_request_history...)
+			m.conversation = append(conversation,
+				chat_message{Role: "user", Content: m.command_request_prompt},
+				chat_message{Role: "assistant", Content: m.response_code_text},
+			)
+
+			m.history_id = newHistoryID()
+
+			return m, appendToHistory(
+				history_list_item{
+					ID:           m.history_id,
+					PromptText:   conversationPrompt(m.conversation),
+					ResponseCode: m.response_code_text,
+					Model:        m.config.Command.Model,
+					Latency:      m.loading_duration,
+				},
+			)
+
+		case GPTcommandStream:
+			// the command requests are shown from the prompt screen, this one was
+			// abandoned before its stream was opened
+			msg.stream.Close()
+			return m, nil
+
+		case GPTcommandError:
+			if !m.is_making_gpt_code_request || msg.stream != m.command_stream || errors.Is(msg.err, context.Canceled) {
+				return m, nil
+			}
 
 			m.loading_duration = time.Since(m.loading_timer).Seconds()
+			m.cancel_command_request()
+			m.cancel_command_request = nil
+			m.command_stream = nil
+			m.is_making_gpt_code_request = false
+			m.prompt_response_screen_err = "❌ " + friendlyRequestError(msg.err)
+
+		case GPTexplanationStream:
+
+			// the request was cancelled while waiting for the stream
+			if !m.is_making_gpt_explanation_request {
+				msg.stream.Close()
+				return m, nil
+			}
+
+			m.explanation_stream = msg.stream
+			return m, waitForGPTexplanationChunk(msg.stream)
+
+		case GPTexplanationChunk:
+			if msg.stream != m.explanation_stream {
+				return m, nil
+			}
 
-			m.command_explanation_text = msg.content
+			m.command_explanation_text += msg.content
 
 			m.explanation_result_viewport.SetContent(renderExplanationResultViewport(m.command_explanation_text))
+			m.explanation_result_viewport.GotoBottom()
+
+			return m, waitForGPTexplanationChunk(msg.stream)
+
+		case GPTexplanationResult:
+			if msg.stream != m.explanation_stream {
+				return m, nil
+			}
+
+			m.loading_duration = time.Since(m.loading_timer).Seconds()
 
+			m.cancel_explanation_request()
+			m.cancel_explanation_request = nil
+			m.explanation_stream = nil
 			m.is_making_gpt_explanation_request = false
 
-			return m, storeExplanationInHistory(m.command_explanation_text)
+			m.explanation_result_viewport.GotoTop()
+
+			// a pasted command is not in the history yet
+			if m.is_explaining_pasted_command {
+				m.is_explaining_pasted_command = false
+				m.history_id = newHistoryID()
+
+				return m, appendToHistory(
+					history_list_item{
+						ID:                  m.history_id,
+						PromptText:          explainPromptText(m.response_code_text),
+						ResponseCode:        m.response_code_text,
+						ResponseExplanation: m.command_explanation_text,
+						Model:               m.config.Explanation.Model,
+					},
+				)
+			}
+
+			return m, storeExplanationInHistory(m.history_id, m.command_explanation_text)
 
 		case GPTexplanationError:
+			if !m.is_making_gpt_explanation_request || msg.stream != m.explanation_stream || errors.Is(msg.err, context.Canceled) {
+				return m, nil
+			}
+
+			m.cancel_explanation_request()
+
+			// only retry when the stream could not be opened, a broken stream already rendered a partial explanation
+			if msg.stream == nil && isRetryableRequestError(msg.err) && m.explanation_retry_attempt < m.config.Explanation.MaxRetries {
+				m.explanation_retry_attempt++
+				request_id := m.explanation_request_id
+
+				return m, tea.Tick(retryBackoff(m.explanation_retry_attempt), func(time.Time) tea.Msg {
+					return retryGPTexplanationRequest{request_id: request_id}
+				})
+			}
+
 			m.loading_duration = time.Since(m.loading_timer).Seconds()
+			m.cancel_explanation_request = nil
+			m.explanation_stream = nil
 			m.is_making_gpt_explanation_request = false
-			m.prompt_response_screen_err = "❌ " + msg.err.Error()
+			m.explanation_retry_attempt = 0
+			m.prompt_response_screen_err = "❌ " + friendlyRequestError(msg.err)
+
+		case retryGPTexplanationRequest:
+			// the request was cancelled or replaced while waiting to retry
+			if !m.is_making_gpt_explanation_request || msg.request_id != m.explanation_request_id {
+				return m, nil
+			}
+
+			ctx, cancel := newRequestContext(m.config.Explanation)
+			m.cancel_explanation_request = cancel
+
+			return m, makeGPTexplanationRequest(ctx, m.provider, m.response_code_text)
 
 		case copyCommandToClipboardResult:
-			return m, tea.Sequence(tea.Quit, sendOutputToChannel(msg.output))
+			return m, tea.Sequence(
+				updateHistory(m.history_id, func(item *history_list_item) {
+					item.Copied = true
+				}),
+				tea.Quit,
+				sendOutputToChannel(msg.output),
+			)
 
 		case copyCommandToClipboardError:
 			m.prompt_response_screen_err = msg.err.Error()
+
+		case writeCommandToShellResult:
+			return m, tea.Sequence(tea.Quit, sendOutputToChannel(""))
+
+		case writeCommandToShellError:
+			m.prompt_response_screen_err = msg.err.Error()
 		}
 
 	case "running_command_screen":
@@ -379,20 +997,152 @@ func updateSelectedScreen(msg tea.Msg, m model) (tea.Model, tea.Cmd) {
 				m.selected_screen = "prompt_response_screen"
 				m.running_command_screen_err = ""
 				return m, nil
+
+			case "f":
+				if m.running_command_screen_err == "" {
+					return m, nil
+				}
+
+				m.running_command_screen_err = ""
+				return startFixRequest(m, failed_command{
+					command:   m.response_code_text,
+					exit_code: m.last_run.ExitCode,
+					stderr:    m.last_run.Stderr,
+				})
+
+			default:
+				var cmd tea.Cmd
+				m.run_output_viewport, cmd = m.run_output_viewport.Update(msg)
+				return m, cmd
 			}
 
 		case RuOnTerminalResultMsg:
-			m.loading_duration = time.Since(m.loading_timer).Seconds()
-			outputMsg := "\n\n" + m.response_code_text + fmt.Sprintf("\n\nTook %.1fs\n\n", m.loading_duration) + msg.output
-			return m, tea.Sequence(tea.Quit, sendOutputToChannel(outputMsg))
+			m.loading_duration = msg.result.Duration
+			// the command output was already shown on the terminal while running
+			outputMsg := fmt.Sprintf("\nTook %.1fs\n", m.loading_duration)
+			return m, tea.Sequence(
+				storeRunResultInHistory(m.history_id, msg.result),
+				tea.Quit,
+				sendOutputToChannel(outputMsg),
+			)
 
 		case RuOnTerminalErrorMsg:
+			m.loading_duration = msg.result.Duration
+			m.last_run = msg.result
+
+			if msg.err != nil {
+				m.running_command_screen_err = "❌ " + msg.err.Error()
+			} else {
+				m.running_command_screen_err = "❌ Command failed with " + msg.result.status()
+			}
+
+			m.run_output_viewport.SetContent(lipgloss.NewStyle().Width(76).Render(renderRunOutput(msg.result)))
+			m.run_output_viewport.GotoTop()
+
+			return m, storeRunResultInHistory(m.history_id, msg.result)
+
+		}
+
+	case "dry_run_screen":
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			if m.is_dry_running {
+				return m, nil
+			}
+
+			switch msg.String() {
+			case "esc":
+				m.selected_screen = "prompt_response_screen"
+				return m, nil
+
+			case "enter":
+				m.selected_screen = "prompt_response_screen"
+				return runResponseCode(m)
+
+			default:
+				var cmd tea.Cmd
+				m.dry_run_viewport, cmd = m.dry_run_viewport.Update(msg)
+				return m, cmd
+			}
+
+		case dryRunResultMsg:
 			m.loading_duration = time.Since(m.loading_timer).Seconds()
-			m.running_command_screen_err = "❌ " + msg.err.Error()
+			m.is_dry_running = false
+			m.last_dry_run = msg.result
+
+			m.dry_run_viewport.SetContent(lipgloss.NewStyle().Width(76).Render(renderDryRunResult(msg.result)))
+			m.dry_run_viewport.GotoTop()
 			return m, nil
 
+		case dryRunErrorMsg:
+			m.loading_duration = time.Since(m.loading_timer).Seconds()
+			m.is_dry_running = false
+			m.dry_run_screen_err = "❌ " + msg.err.Error()
+			return m, nil
 		}
 
+	case "confirm_run_screen":
+		var cmd tea.Cmd
+
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch msg.String() {
+			case "esc":
+				m.selected_screen = "prompt_response_screen"
+				return m, nil
+
+			case "enter":
+				if m.confirm_run_textInput.Value() != "yes" {
+					m.confirm_run_screen_err = "❌ Type yes to run the command"
+					return m, nil
+				}
+
+				m.loading_timer = time.Now()
+				m.selected_screen = "running_command_screen"
+
+				return m, runOnTerminal(m.response_code_text)
+			}
+
+			m.confirm_run_screen_err = ""
+			m.confirm_run_textInput, cmd = m.confirm_run_textInput.Update(msg)
+			return m, cmd
+		}
+
+	case "explain_input_screen":
+		var cmd tea.Cmd
+
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch msg.String() {
+			case "esc":
+				m.selected_screen = "prompt_screen"
+				return m, textarea.Blink
+
+			case "enter":
+				command := strings.TrimSpace(m.explain_textInput.Value())
+				if command == "" {
+					m.explain_screen_err = "❌ Command cannot be empty"
+					return m, nil
+				}
+
+				m = setResponse(m, command_response{Command: command})
+				m.prompt_response_screen_err = ""
+				m.explanation_result_viewport.SetContent("")
+
+				// there is no prompt to refine or to generate alternatives for
+				m.conversation = nil
+				m.is_explaining_pasted_command = true
+
+				m.selected_screen = "prompt_response_screen"
+				return startGPTexplanationRequest(m)
+			}
+
+			m.explain_screen_err = ""
+		}
+
+		m.explain_textInput, cmd = m.explain_textInput.Update(msg)
+		return m, cmd
+
 	case "response_edit_screen":
 		var cmd tea.Cmd
 
@@ -405,13 +1155,23 @@ func updateSelectedScreen(msg tea.Msg, m model) (tea.Model, tea.Cmd) {
 
 			case "enter":
 				if m.response_code_textInput.Value() != m.response_code_text {
-					m.response_code_text = m.response_code_textInput.Value()
+					// the history entry keeps track of the edit
+					history_id := m.history_id
 
-					// re-render the code in the viewport
-					m.response_code_viewport.SetContent(m.response_code_text)
+					// the description of the model no longer applies to the edited code
+					m = setResponse(m, command_response{Command: m.response_code_textInput.Value()})
+					m.history_id = history_id
 
 					// we just updated the code so the explanation is no longer valid
 					m.command_explanation_text = ""
+
+					// refinements continue from the edited command
+					if len(m.conversation) > 0 {
+						m.conversation[len(m.conversation)-1].Content = m.response_code_text
+					}
+
+					m.selected_screen = "prompt_response_screen"
+					return m, storeEditInHistory(m.history_id, m.response_code_text)
 				}
 				m.selected_screen = "prompt_response_screen"
 				return m, nil
@@ -421,24 +1181,246 @@ func updateSelectedScreen(msg tea.Msg, m model) (tea.Model, tea.Cmd) {
 			return m, cmd
 		}
 
+	case "alternatives_screen":
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			if m.is_loading_alternatives {
+				if msg.String() == "esc" {
+					m.cancel_alternatives_request()
+					m.cancel_alternatives_request = nil
+					m.is_loading_alternatives = false
+					m.selected_screen = "prompt_response_screen"
+				}
+				return m, nil
+			}
+
+			// while filtering the keys go to the filter input
+			if m.alternatives_list.FilterState() != list.Filtering {
+				switch msg.String() {
+				case "esc":
+					// esc clears the filter first
+					if m.alternatives_list.FilterState() == list.Unfiltered {
+						m.selected_screen = "prompt_response_screen"
+						return m, nil
+					}
+
+				case "enter":
+					selected, ok := m.alternatives_list.SelectedItem().(alternative_list_item)
+					if !ok {
+						return m, nil
+					}
+
+					m = setResponse(m, selected.response)
+					m.command_explanation_text = ""
+					m.prompt_response_screen_err = ""
+					m.explanation_result_viewport.SetContent("")
+
+					// refinements continue from the chosen command
+					m.conversation[len(m.conversation)-1].Content = m.response_code_text
+
+					m.history_id = newHistoryID()
+
+					m.selected_screen = "prompt_response_screen"
+					return m, appendToHistory(
+						history_list_item{
+							ID:           m.history_id,
+							PromptText:   conversationPrompt(m.conversation),
+							ResponseCode: m.response_code_text,
+							Model:        m.config.Command.Model,
+							Latency:      m.loading_duration,
+						},
+					)
+				}
+			}
+
+		case tea.WindowSizeMsg:
+			w, h := history_list_style.GetFrameSize()
+			m.alternatives_list.SetSize(msg.Width-w, msg.Height-h)
+			return m, nil
+
+		case GPTalternativesResult:
+			if !m.is_loading_alternatives || msg.request_id != m.alternatives_request_id {
+				return m, nil
+			}
+
+			m.loading_duration = time.Since(m.loading_timer).Seconds()
+			m.cancel_alternatives_request()
+			m.cancel_alternatives_request = nil
+			m.is_loading_alternatives = false
+
+			if len(msg.responses) == 0 {
+				m.alternatives_screen_err = "❌ No alternatives were generated"
+				return m, nil
+			}
+
+			items := make([]list.Item, len(msg.responses))
+			for i, response := range msg.responses {
+				items[i] = alternative_list_item{
+					response: response,
+					summary:  summarizeCommand(response),
+				}
+			}
+			m.alternatives_list.ResetFilter()
+			m.alternatives_list.Select(0)
+
+			w, h := history_list_style.GetFrameSize()
+			m.alternatives_list.SetSize(m.terminal_width-w, m.terminal_height-h)
+
+			return m, m.alternatives_list.SetItems(items)
+
+		case GPTalternativesError:
+			if !m.is_loading_alternatives || msg.request_id != m.alternatives_request_id || errors.Is(msg.err, context.Canceled) {
+				return m, nil
+			}
+
+			m.loading_duration = time.Since(m.loading_timer).Seconds()
+			m.cancel_alternatives_request()
+			m.cancel_alternatives_request = nil
+			m.is_loading_alternatives = false
+			m.alternatives_screen_err = "❌ " + friendlyRequestError(msg.err)
+			return m, nil
+		}
+
+		if m.is_loading_alternatives || m.alternatives_screen_err != "" {
+			return m, nil
+		}
+
+		var cmd tea.Cmd
+		m.alternatives_list, cmd = m.alternatives_list.Update(msg)
+		return m, cmd
+
+	case "history_edit_screen":
+		var cmd tea.Cmd
+
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch msg.String() {
+			case "esc":
+				m.selected_screen = "history_screen"
+				return m, nil
+
+			case "enter":
+				value := strings.TrimSpace(m.history_edit_textInput.Value())
+				id := m.history_edit_item.ID
+
+				var store tea.Cmd
+				if m.history_edit_field == "tags" {
+					tags := parseHistoryTags(value)
+					store = updateHistory(id, func(item *history_list_item) {
+						item.Tags = tags
+					})
+				} else {
+					if value == "" {
+						m.history_edit_screen_err = "❌ Command cannot be empty"
+						return m, nil
+					}
+					if value == m.history_edit_item.ResponseCode {
+						m.selected_screen = "history_screen"
+						return m, nil
+					}
+					store = replaceCommandInHistory(id, value)
+				}
+
+				m.selected_screen = "history_screen"
+				return m, tea.Sequence(store, loadHistoryFromFile)
+			}
+
+			m.history_edit_screen_err = ""
+		}
+
+		m.history_edit_textInput, cmd = m.history_edit_textInput.Update(msg)
+		return m, cmd
+
 	case "history_screen":
 		switch msg := msg.(type) {
 		case tea.KeyMsg:
+			// the keys are typed in the search
+			if m.history_list.FilterState() == list.Filtering && msg.String() != "ctrl+c" {
+				break
+			}
+
+			selected, ok := m.history_list.SelectedItem().(history_list_item)
+
+			// x has to be pressed twice to delete
+			confirming_delete := m.history_delete_id
+			m.history_delete_id = ""
+
 			switch msg.String() {
 			case "ctrl+c":
 				m.selected_screen = "prompt_screen"
 				return m, nil
 
+			case "x":
+				if !ok {
+					return m, nil
+				}
+
+				if confirming_delete != selected.ID {
+					m.history_delete_id = selected.ID
+					return m, m.history_list.NewStatusMessage(warning_style.Render("Press x again to delete the entry"))
+				}
+
+				return m, tea.Sequence(
+					deleteFromHistory(selected.ID),
+					loadHistoryFromFile,
+				)
+
+			case "p":
+				if !ok {
+					return m, nil
+				}
+
+				return m, tea.Sequence(
+					updateHistory(selected.ID, func(item *history_list_item) {
+						item.Pinned = !item.Pinned
+					}),
+					loadHistoryFromFile,
+				)
+
+			case "t":
+				if !ok {
+					return m, nil
+				}
+
+				m.history_edit_item = selected
+				m.history_edit_field = "tags"
+				m.history_edit_textInput.Placeholder = "docker, cleanup"
+				m.history_edit_textInput.SetValue(strings.Join(selected.Tags, ", "))
+				m.history_edit_textInput.Focus()
+				m.history_edit_screen_err = ""
+				m.selected_screen = "history_edit_screen"
+				return m, textinput.Blink
+
+			case "e":
+				if !ok {
+					return m, nil
+				}
+
+				m.history_edit_item = selected
+				m.history_edit_field = "command"
+				m.history_edit_textInput.Placeholder = ""
+				m.history_edit_textInput.SetValue(selected.ResponseCode)
+				m.history_edit_textInput.Focus()
+				m.history_edit_screen_err = ""
+				m.selected_screen = "history_edit_screen"
+				return m, textinput.Blink
+
 			case "enter":
-				selected := m.history_list.SelectedItem().(history_list_item)
+				if !ok {
+					return m, nil
+				}
 
-				m.response_code_text = selected.ResponseCode
+				m = setResponse(m, command_response{Command: selected.ResponseCode})
+				m.history_id = selected.ID
 				m.command_explanation_text = selected.ResponseExplanation
 
-				m.response_code_viewport.SetContent(renderResponseCodeViewport(m.response_code_text))
-
 				m.explanation_result_viewport.SetContent(renderExplanationResultViewport(m.command_explanation_text))
 
+				m.conversation = []chat_message{
+					{Role: "user", Content: selected.PromptText},
+					{Role: "assistant", Content: selected.ResponseCode},
+				}
+
 				m.selected_screen = "prompt_response_screen"
 				return m, nil
 			}
@@ -449,16 +1431,23 @@ func updateSelectedScreen(msg tea.Msg, m model) (tea.Model, tea.Cmd) {
 
 		case HistoryFromFileResult:
 
-			items := make([]list.Item, len(msg.history))
+			history := make([]history_list_item, 0, len(msg.history))
 
-			for i, item := range msg.history {
-				items[len(items)-1-i] = history_list_item{
-					PromptText:          item.PromptText,
-					ResponseCode:        item.ResponseCode,
-					ResponseExplanation: item.ResponseExplanation,
+			// the pinned entries first, then the most recent
+			for _, pinned := range []bool{true, false} {
+				for i := len(msg.history) - 1; i >= 0; i-- {
+					if msg.history[i].Pinned == pinned {
+						history = append(history, msg.history[i])
+					}
 				}
 			}
+
+			items := make([]list.Item, len(history))
+			for i, item := range history {
+				items[i] = item
+			}
 			m.history_list.SetItems(items)
+			m.history_list.Filter = historyFilter(history)
 
 			w, h := history_list_style.GetFrameSize()
 			m.history_list.SetSize(m.terminal_width-w, m.terminal_height-h)
@@ -474,27 +1463,416 @@ func updateSelectedScreen(msg tea.Msg, m model) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+/**
+* Starts a command generation request for the prompt, following the
+* history of a previous command when refining it. Both are kept so the
+* request can be retried
+**/
+func startGPTcommandRequest(m model, history []chat_message, prompt string) (tea.Model, tea.Cmd) {
+	m.loading_timer = time.Now()
+	m.is_making_gpt_code_request = true
+	m.command_request_id++
+	m.command_retry_attempt = 0
+	m.command_request_history = history
+	m.command_request_prompt = prompt
+
+	ctx, cancel := newRequestContext(m.config.Command)
+	m.cancel_command_request = cancel
+
+	return m, makeGPTcommandRequest(ctx, m.provider, history, prompt)
+}
+
+/**
+* Starts the explanation request of the response code
+**/
+func startGPTexplanationRequest(m model) (tea.Model, tea.Cmd) {
+	m.loading_timer = time.Now()
+	m.is_making_gpt_explanation_request = true
+	m.command_explanation_text = ""
+	m.prompt_response_screen_err = ""
+	m.explanation_request_id++
+	m.explanation_retry_attempt = 0
+
+	ctx, cancel := newRequestContext(m.config.Explanation)
+	m.cancel_explanation_request = cancel
+
+	return m, makeGPTexplanationRequest(ctx, m.provider, m.response_code_text)
+}
+
+/**
+* Shows the command response on the response screen and checks that the
+* tools it needs are installed
+**/
+func setResponse(m model, response command_response) model {
+	m.response_code_text = response.Command
+	m.response_description = response.Description
+	m.response_risk = response.Risk
+
+	m.response_code_viewport.SetContent(renderResponseCodeViewport(m.response_code_text))
+
+	m.missing_tools = findMissingTools(response.Command, response.RequiredTools)
+	m.show_install_command = false
+
+	// the callers set it when the response has an entry in the history
+	m.history_id = ""
+
+	return m
+}
+
+/**
+* Leaves the refine mode of the prompt screen restoring the original prompt
+**/
+func stopRefining(m model) model {
+	m.is_refining = false
+	m.prompt_textarea.SetValue(m.refine_previous_prompt)
+	m.prompt_textarea.Placeholder = prompt_placeholder
+	m.refine_previous_prompt = ""
+	return m
+}
+
+/**
+* Summary of the prompts of a conversation, eg: "find big files → only .png".
+* Only the first line of each prompt is kept
+**/
+func conversationPrompt(conversation []chat_message) string {
+	var prompts []string
+	for _, message := range conversation {
+		if message.Role == "user" {
+			prompts = append(prompts, strings.SplitN(message.Content, "\n", 2)[0])
+		}
+	}
+
+	return strings.Join(prompts, " → ")
+}
+
+/**
+* Runs the response code, going through the confirmation screen first
+* when the command looks dangerous
+**/
+func runResponseCode(m model) (tea.Model, tea.Cmd) {
+
+	// with the shell integration the command goes to the command line instead of running it
+	if m.shell_output_file != "" {
+		return m, writeCommandToShell(m.shell_output_file, m.response_code_text)
+	}
+
+	// dangerous commands need an explicit confirmation before running
+	if len(analyzeCommandRisk(m.response_code_text)) > 0 {
+		m.confirm_run_textInput.SetValue("")
+		m.confirm_run_screen_err = ""
+		m.selected_screen = "confirm_run_screen"
+		return m, textinput.Blink
+	}
+
+	m.loading_timer = time.Now()
+	m.selected_screen = "running_command_screen"
+
+	return m, runOnTerminal(m.response_code_text)
+}
+
 /**
 * "Game loop" that updates the screen everytime the model changes.
 *  The function returns a string of the UI to be rendered
  */
 func (m model) View() string {
+	view := viewSelectedScreen(m)
+
+	// the history errors can happen on any screen
+	if m.history_store_err != "" {
+		view += "\n" + m.history_store_err + "\n"
+	}
+
+	return view
+}
+
+func viewSelectedScreen(m model) string {
+
+	switch m.selected_screen {
+	case "prompt_screen":
+		// The header
+		s := "Your prompt\n\n"
+		if m.is_refining {
+			s = "Refine: " + m.response_code_text + "\n\n"
+		}
+
+		s += m.prompt_textarea.View()
+
+		if m.prompt_screen_err != "" {
+			s += "\n\n"
+			s += m.prompt_screen_err
+		}
+
+		if m.is_making_gpt_code_request && m.command_retry_attempt > 0 {
+			s += "\n\n"
+			s += m.loading_spinner.View() + fmt.Sprintf(" Retrying (%d/%d)…", m.command_retry_attempt, m.config.Command.MaxRetries) + fmt.Sprintf(" %.1fs", time.Since(m.loading_timer).Seconds()) + " (esc to cancel)\n\n"
+		} else if m.is_making_gpt_code_request {
+			s += "\n\n"
+			s += m.loading_spinner.View() + " Making request..." + fmt.Sprintf(" %.1fs", time.Since(m.loading_timer).Seconds()) + " (esc to cancel)\n\n"
+		}
+
+		// The footer
+		s += strings.Repeat("\n", 4)
+
+		if m.is_refining {
+			s += m.help.FullHelpView([][]key.Binding{
+				{
+					key.NewBinding(
+						key.WithKeys("ctrl+s"),
+						key.WithHelp("[ ctrl+s ]", "✔︎ Refine"),
+					),
+					key.NewBinding(
+						key.WithKeys("esc"),
+						key.WithHelp("[ esc    ]", "↩︎ Back to the command"),
+					),
+					key.NewBinding(
+						key.WithKeys("ctrl+c"),
+						key.WithHelp("[ ctrl+c ]", "⏏︎ Exit"),
+					),
+				},
+			})
+			return screen_style.Render(s)
+		}
+
+		s += m.help.FullHelpView([][]key.Binding{
+			{
+				key.NewBinding(
+					key.WithKeys("ctrl+s"),
+					key.WithHelp("[ ctrl+s ]", "✔︎ Start"),
+				),
+				key.NewBinding(
+					key.WithKeys("ctrl+h"),
+					key.WithHelp("[ ctrl+h ]", "⍞ History"),
+				),
+				key.NewBinding(
+					key.WithKeys("ctrl+x"),
+					key.WithHelp("[ ctrl+x ]", "␦ Explain a command"),
+				),
+
+				key.NewBinding(
+					key.WithKeys("ctrl+c"),
+					key.WithHelp("[ ctrl+c ]", "⏏︎ Exit"),
+				),
+			},
+		})
+
+		// Send the UI for rendering
+		return screen_style.Render(s)
+
+	case "prompt_response_screen":
+		s := "Result\n"
+
+		// show the path that led to a refined command
+		if len(m.conversation) > 2 && !m.is_making_gpt_code_request {
+			s = "Result of: " + conversationPrompt(m.conversation) + "\n"
+		}
+
+		s += m.response_code_viewport.View()
+
+		if !m.is_making_gpt_code_request {
+			if m.response_description != "" {
+				s += "\n" + m.response_description
+			}
+
+			// the model's own assessment, the dangerous patterns below are detected locally
+			if m.response_risk == "medium" || m.response_risk == "high" {
+				s += "\n"
+				s += warning_style.Render("Risk: " + m.response_risk)
+			}
+
+			if risks := analyzeCommandRisk(m.response_code_text); len(risks) > 0 {
+				s += "\n"
+				s += warning_style.Render("⚠︎ Dangerous: " + strings.Join(risks, ", "))
+			}
+
+			if len(m.missing_tools) > 0 {
+				s += "\n"
+				s += warning_style.Render("⚠︎ Not installed: " + strings.Join(m.missing_tools, ", "))
+			}
+
+			if m.show_install_command {
+				if install_command := installToolsCommand(m.missing_tools); install_command != "" {
+					s += "\nInstall with: " + install_command
+				} else {
+					s += "\nNo known package manager found to install them"
+				}
+			}
+		}
+
+		if m.prompt_response_screen_err != "" {
+			s += "\n\n"
+			s += m.prompt_response_screen_err
+		}
+
+		if m.is_making_gpt_code_request {
+			s += "\n\n"
+			s += m.loading_spinner.View() + " Generating command..." + fmt.Sprintf(" %.1fs\n\n", time.Since(m.loading_timer).Seconds())
+		}
+
+		if m.is_making_gpt_explanation_request && m.explanation_retry_attempt > 0 {
+			s += "\n\n"
+			s += m.loading_spinner.View() + fmt.Sprintf(" Retrying (%d/%d)…", m.explanation_retry_attempt, m.config.Explanation.MaxRetries) + fmt.Sprintf(" %.1fs\n\n", time.Since(m.loading_timer).Seconds())
+		} else if m.is_making_gpt_explanation_request {
+			s += "\n\n"
+			s += m.loading_spinner.View() + " Loading explanation..." + fmt.Sprintf(" %.1fs\n\n", time.Since(m.loading_timer).Seconds())
+		}
+
+		if m.command_explanation_text != "" {
+			s += "\n\n"
+			s += "Explanation\n"
+			s += m.explanation_result_viewport.View()
+		}
+
+		if !m.is_making_gpt_code_request && !m.is_making_gpt_explanation_request {
+			s += fmt.Sprintf("\n\nTook %.1fs\n\n", m.loading_duration)
+		}
+
+		// The footer
+		s += strings.Repeat("\n", 4)
+
+		if m.is_making_gpt_code_request || m.is_making_gpt_explanation_request {
+			s += m.help.FullHelpView([][]key.Binding{
+				{
+					key.NewBinding(
+						key.WithKeys("esc"),
+						key.WithHelp("[  esc    ]", "✕ Cancel"),
+					),
+					key.NewBinding(
+						key.WithKeys("ctrl+c"),
+						key.WithHelp("[  ctrl+c ]", "⏏︎ Exit"),
+					),
+				},
+			})
+			return screen_style.Render(s)
+		}
+
+		enter_help := "✔︎ Run"
+		if m.shell_output_file != "" {
+			enter_help = "✔︎ Insert in command line"
+		}
+
+		key_bindings := []key.Binding{
+			key.NewBinding(
+				key.WithKeys("enter"),
+				key.WithHelp("[  enter  ]", enter_help),
+			),
+		}
+
+		// the shell integration doesn't run commands so there is nothing to dry run
+		if m.shell_output_file == "" {
+			key_bindings = append(key_bindings, key.NewBinding(
+				key.WithKeys("d"),
+				key.WithHelp("[  d      ]", "⎚ Dry run in a sandbox"),
+			))
+		}
+
+		if len(m.conversation) > 0 {
+			key_bindings = append(key_bindings, key.NewBinding(
+				key.WithKeys("r"),
+				key.WithHelp("[  r      ]", "↪ Refine with a follow-up"),
+			))
+		}
+
+		if len(m.conversation) > 0 {
+			key_bindings = append(key_bindings, key.NewBinding(
+				key.WithKeys("n"),
+				key.WithHelp("[  n      ]", "☰ Show alternatives"),
+			))
+		}
+
+		if len(m.missing_tools) > 0 {
+			if len(m.conversation) > 0 {
+				key_bindings = append(key_bindings, key.NewBinding(
+					key.WithKeys("a"),
+					key.WithHelp("[  a      ]", "↻ Regenerate with installed tools only"),
+				))
+			}
+			key_bindings = append(key_bindings, key.NewBinding(
+				key.WithKeys("i"),
+				key.WithHelp("[  i      ]", "⤓ Show install command"),
+			))
+		}
+
+		key_bindings = append(key_bindings,
+			key.NewBinding(
+				key.WithKeys("e"),
+				key.WithHelp("[  e      ]", "␦ Explain code"),
+			),
+			key.NewBinding(
+				key.WithKeys("m"),
+				key.WithHelp("[  m      ]", "✎ Modify code"),
+			),
+
+			key.NewBinding(
+				key.WithKeys("c"),
+				key.WithHelp("[  c      ]", "☑︎ Copy code to clipboard"),
+			),
+			key.NewBinding(
+				key.WithKeys("esc"),
+				key.WithHelp("[  esc    ]", "↩︎ Go back and amend prompt"),
+			),
+			key.NewBinding(
+				key.WithKeys("ctrl+c"),
+				key.WithHelp("[  ctrl+c ]", "⏏︎ Exit"),
+			),
+		)
+
+		s += m.help.FullHelpView([][]key.Binding{key_bindings})
+		return screen_style.Render(s)
+
+	case "running_command_screen":
+		s := "Running command: " + m.response_code_text + "\n\n"
+
+		if m.running_command_screen_err != "" {
+			s += "\n\n"
+			s += m.running_command_screen_err
+
+			s += fmt.Sprintf("\n\nTook %.1fs\n\n", m.loading_duration)
+
+			if m.last_run.Stdout != "" || m.last_run.Stderr != "" {
+				s += "Output\n"
+				s += m.run_output_viewport.View()
+			}
+
+			// The footer
+			s += strings.Repeat("\n", 4)
+			s += m.help.FullHelpView([][]key.Binding{
+				{
+					key.NewBinding(
+						key.WithKeys("f"),
+						key.WithHelp("[ f      ]", "⚒ Fix the command"),
+					),
+					key.NewBinding(
+						key.WithKeys("esc"),
+						key.WithHelp("[ esc    ]", "↩︎ Go back"),
+					),
+					key.NewBinding(
+						key.WithKeys("ctrl+c"),
+						key.WithHelp("[ ctrl+c ]", "⏏︎ Exit"),
+					),
+				},
+			})
 
-	switch m.selected_screen {
-	case "prompt_screen":
-		// The header
-		s := "Your prompt\n\n"
+		} else {
+			s += m.loading_spinner.View() + " Processing..." + fmt.Sprintf(" %.1fs\n\n", time.Since(m.loading_timer).Seconds())
+		}
+		return screen_style.Render(s)
 
-		s += m.prompt_textarea.View()
+	case "dry_run_screen":
+		s := "Dry run: " + m.response_code_text + "\n\n"
 
-		if m.prompt_screen_err != "" {
-			s += "\n\n"
-			s += m.prompt_screen_err
+		if m.is_dry_running {
+			s += m.loading_spinner.View() + " Running in a sandbox..." + fmt.Sprintf(" %.1fs\n\n", time.Since(m.loading_timer).Seconds())
+			return screen_style.Render(s)
 		}
 
-		if m.is_making_gpt_code_request {
-			s += "\n\n"
-			s += m.loading_spinner.View() + " Making request..." + fmt.Sprintf(" %.1fs\n\n", time.Since(m.loading_timer).Seconds())
+		run_key_help := "✔︎ Run for real"
+
+		if m.dry_run_screen_err != "" {
+			s += m.dry_run_screen_err
+			run_key_help = "✔︎ Run anyway"
+		} else {
+			s += "Sandbox " + m.last_dry_run.run.status() + fmt.Sprintf(", took %.1fs\n\n", m.loading_duration)
+			s += m.dry_run_viewport.View()
 		}
 
 		// The footer
@@ -502,100 +1880,130 @@ func (m model) View() string {
 		s += m.help.FullHelpView([][]key.Binding{
 			{
 				key.NewBinding(
-					key.WithKeys("ctrl+s"),
-					key.WithHelp("[ ctrl+s ]", "✔︎ Start"),
+					key.WithKeys("enter"),
+					key.WithHelp("[ enter  ]", run_key_help),
 				),
 				key.NewBinding(
-					key.WithKeys("ctrl+h"),
-					key.WithHelp("[ ctrl+h ]", "⍞ History"),
+					key.WithKeys("esc"),
+					key.WithHelp("[ esc    ]", "↩︎ Go back"),
 				),
-
 				key.NewBinding(
 					key.WithKeys("ctrl+c"),
 					key.WithHelp("[ ctrl+c ]", "⏏︎ Exit"),
 				),
 			},
 		})
-
-		// Send the UI for rendering
 		return screen_style.Render(s)
 
-	case "prompt_response_screen":
-		s := "Result\n"
+	case "confirm_run_screen":
+		s := warning_style.Render("⚠︎ This command looks dangerous") + "\n"
 
 		s += m.response_code_viewport.View()
+		s += "\n\n"
 
-		if m.prompt_response_screen_err != "" {
-			s += "\n\n"
-			s += m.prompt_response_screen_err
+		for _, risk := range analyzeCommandRisk(m.response_code_text) {
+			s += warning_style.Render("• "+risk) + "\n"
 		}
 
-		if m.is_making_gpt_explanation_request {
-			s += "\n\n"
-			s += m.loading_spinner.View() + " Loading explanation..." + fmt.Sprintf(" %.1fs\n\n", time.Since(m.loading_timer).Seconds())
-		}
+		s += "\nType yes to run it anyway\n\n"
+		s += m.confirm_run_textInput.View()
 
-		if m.command_explanation_text != "" && !m.is_making_gpt_explanation_request {
+		if m.confirm_run_screen_err != "" {
 			s += "\n\n"
-			s += "Explanation\n"
-			s += m.explanation_result_viewport.View()
+			s += m.confirm_run_screen_err
 		}
 
-		if !m.is_making_gpt_code_request && !m.is_making_gpt_explanation_request {
-			s += fmt.Sprintf("\n\nTook %.1fs\n\n", m.loading_duration)
+		// The footer
+		s += strings.Repeat("\n", 4)
+		s += m.help.FullHelpView([][]key.Binding{
+			{
+				key.NewBinding(
+					key.WithKeys("enter"),
+					key.WithHelp("[ enter  ]", "✔︎ Run"),
+				),
+				key.NewBinding(
+					key.WithKeys("esc"),
+					key.WithHelp("[ esc    ]", "↩︎ Go back"),
+				),
+				key.NewBinding(
+					key.WithKeys("ctrl+c"),
+					key.WithHelp("[ ctrl+c ]", "⏏︎ Exit"),
+				),
+			},
+		})
+		return screen_style.Render(s)
+
+	case "explain_input_screen":
+		s := "Paste a command to explain\n\n"
+
+		s += m.explain_textInput.View()
+
+		if m.explain_screen_err != "" {
+			s += "\n\n"
+			s += m.explain_screen_err
 		}
 
 		// The footer
 		s += strings.Repeat("\n", 4)
-
 		s += m.help.FullHelpView([][]key.Binding{
 			{
-
 				key.NewBinding(
 					key.WithKeys("enter"),
-					key.WithHelp("[  enter  ]", "✔︎ Run"),
+					key.WithHelp("[ enter  ]", "␦ Explain"),
 				),
 				key.NewBinding(
-					key.WithKeys("e"),
-					key.WithHelp("[  e      ]", "␦ Explain code"),
+					key.WithKeys("esc"),
+					key.WithHelp("[ esc    ]", "↩︎ Go back"),
 				),
 				key.NewBinding(
-					key.WithKeys("m"),
-					key.WithHelp("[  m      ]", "✎ Modify code"),
+					key.WithKeys("ctrl+c"),
+					key.WithHelp("[ ctrl+c ]", "⏏︎ Exit"),
 				),
+			},
+		})
+		return screen_style.Render(s)
+
+	case "response_edit_screen":
+		s := "Edit the result command\n\n"
 
+		s += m.response_code_textInput.View()
+
+		// The footer
+		s += strings.Repeat("\n", 4)
+		s += m.help.FullHelpView([][]key.Binding{
+			{
 				key.NewBinding(
-					key.WithKeys("c"),
-					key.WithHelp("[  c      ]", "☑︎ Copy code to clipboard"),
+					key.WithKeys("enter"),
+					key.WithHelp("[ enter  ]", "✔︎ Save"),
 				),
 				key.NewBinding(
 					key.WithKeys("esc"),
-					key.WithHelp("[  esc    ]", "↩︎ Go back and amend prompt"),
+					key.WithHelp("[ esc    ]", "↩︎ Go back"),
 				),
 				key.NewBinding(
 					key.WithKeys("ctrl+c"),
-					key.WithHelp("[  ctrl+c ]", "⏏︎ Exit"),
+					key.WithHelp("[ ctrl+c ]", "⏏︎ Exit"),
 				),
 			},
 		})
 		return screen_style.Render(s)
 
-	case "running_command_screen":
-		s := "Running command: " + m.response_code_text + "\n\n"
-
-		if m.running_command_screen_err != "" {
-			s += "\n\n"
-			s += m.running_command_screen_err
+	case "alternatives_screen":
+		if m.is_loading_alternatives {
+			s := fmt.Sprintf("Alternatives for: %s\n\n", conversationPrompt(m.conversation))
+			s += m.loading_spinner.View() + fmt.Sprintf(" Generating %d alternatives...", m.config.Alternatives) + fmt.Sprintf(" %.1fs", time.Since(m.loading_timer).Seconds()) + " (esc to cancel)\n\n"
+			return screen_style.Render(s)
+		}
 
-			s += fmt.Sprintf("\n\nTook %.1fs\n\n", m.loading_duration)
+		if m.alternatives_screen_err != "" {
+			s := m.alternatives_screen_err
 
-			// The footer
 			s += strings.Repeat("\n", 4)
 			s += m.help.FullHelpView([][]key.Binding{
 				{
 					key.NewBinding(
 						key.WithKeys("esc"),
-						key.WithHelp("[ esc ]", "↩︎ Go back"),
+						key.WithHelp("[ esc    ]", "↩︎ Go back"),
 					),
 					key.NewBinding(
 						key.WithKeys("ctrl+c"),
@@ -603,16 +2011,25 @@ func (m model) View() string {
 					),
 				},
 			})
+			return screen_style.Render(s)
+		}
 
-		} else {
-			s += m.loading_spinner.View() + " Processing..." + fmt.Sprintf(" %.1fs\n\n", time.Since(m.loading_timer).Seconds())
+		return history_list_style.Render(m.alternatives_list.View())
+
+	case "history_screen":
+		return history_list_style.Render(m.history_list.View())
+
+	case "history_edit_screen":
+		s := "Edit the command of: " + m.history_edit_item.PromptText + "\n\n"
+		if m.history_edit_field == "tags" {
+			s = "Tags of: " + m.history_edit_item.PromptText + " (separated by commas or spaces)\n\n"
 		}
-		return screen_style.Render(s)
 
-	case "response_edit_screen":
-		s := "Edit the result command\n\n"
+		s += m.history_edit_textInput.View()
 
-		s += m.response_code_textInput.View()
+		if m.history_edit_screen_err != "" {
+			s += "\n\n" + m.history_edit_screen_err
+		}
 
 		// The footer
 		s += strings.Repeat("\n", 4)
@@ -626,17 +2043,10 @@ func (m model) View() string {
 					key.WithKeys("esc"),
 					key.WithHelp("[ esc    ]", "↩︎ Go back"),
 				),
-				key.NewBinding(
-					key.WithKeys("ctrl+c"),
-					key.WithHelp("[ ctrl+c ]", "⏏︎ Exit"),
-				),
 			},
 		})
 		return screen_style.Render(s)
 
-	case "history_screen":
-		return history_list_style.Render(m.history_list.View())
-
 	default:
 		return ""
 
@@ -645,36 +2055,91 @@ func (m model) View() string {
 }
 
 type RuOnTerminalResultMsg struct {
-	output string
+	result run_result
 }
 
 type RuOnTerminalErrorMsg struct {
-	err error
+	result run_result
+	err    error
 }
 
-func runOnTerminal(command string) tea.Cmd {
-	return func() tea.Msg {
+// terminal_command runs a command with the terminal attached so the output
+// is shown as it is produced and interactive commands can read from the TTY
+type terminal_command struct {
+	*exec.Cmd
+	command string
+	result  run_result
+}
 
-		c := exec.Command("bash", "-c", command)
+func (c *terminal_command) SetStdin(r io.Reader) {
+	if c.Stdin == nil {
+		c.Stdin = r
+	}
+}
 
-		var stdout, stderr bytes.Buffer
-		c.Stdout = &stdout
-		c.Stderr = &stderr
+func (c *terminal_command) SetStdout(w io.Writer) {
+	if c.Stdout == nil {
+		c.Stdout = w
+	}
+}
 
-		err := c.Run()
+func (c *terminal_command) SetStderr(w io.Writer) {
+	if c.Stderr == nil {
+		c.Stderr = w
+	}
+}
 
-		// debug
-		// time.Sleep(1 * time.Second)
+func (c *terminal_command) Run() error {
+	fmt.Fprintln(c.Stdout, "$ "+c.command)
 
-		if err != nil {
-			return RuOnTerminalErrorMsg{err: fmt.Errorf(stderr.String())}
-		}
+	// the outputs stay attached to the terminal so the command sees a TTY,
+	// eg: colors, vim or less. Only the outcome of the run is recorded
+	result, err := runCommand(c.Cmd)
+	c.result = result
 
-		return RuOnTerminalResultMsg{
-			output: stdout.String(),
-		}
+	return err
+}
+
+// shells that run the POSIX commands the models generate and shell_parse.go understands
+var posix_shells = map[string]bool{
+	"sh":   true,
+	"bash": true,
+	"zsh":  true,
+	"dash": true,
+	"ksh":  true,
+	"mksh": true,
+	"ash":  true,
+	"yash": true,
+}
+
+/**
+* Returns the user's shell, falls back to bash when it is not POSIX
+* compatible, eg: fish, so the commands run in the shell they were made for
+**/
+func userShell() string {
+	if shell := os.Getenv("SHELL"); shell != "" && posix_shells[filepath.Base(shell)] {
+		return shell
+	}
+
+	return "bash"
+}
 
+/**
+* Suspends the TUI and runs the command in the user's shell
+**/
+func runOnTerminal(command string) tea.Cmd {
+	c := &terminal_command{
+		Cmd:     exec.Command(userShell(), "-c", command),
+		command: command,
 	}
+
+	return tea.Exec(c, func(err error) tea.Msg {
+		if err != nil || !c.result.succeeded() {
+			return RuOnTerminalErrorMsg{result: c.result, err: err}
+		}
+
+		return RuOnTerminalResultMsg{result: c.result}
+	})
 }
 
 var outputCh = make(chan string)
@@ -686,142 +2151,140 @@ func sendOutputToChannel(output string) tea.Cmd {
 	}
 }
 
-type GPTcommandResult struct {
+// GPTcommandStream is sent once the provider starts streaming the command
+type GPTcommandStream struct {
+	stream completion_stream
+}
+
+type GPTcommandChunk struct {
+	stream  completion_stream
 	content string
 }
 
+// GPTcommandResult is sent once the whole command was streamed
+type GPTcommandResult struct {
+	stream completion_stream
+}
+
 type GPTcommandError struct {
-	err error
+	stream completion_stream
+	err    error
 }
 
-func makeGPTcommandRequest(prompt string) tea.Cmd {
-	return func() tea.Msg {
+type retryGPTcommandRequest struct {
+	request_id int
+}
 
-		client := openai.NewClient(os.Getenv("OPENAI_API_KEY"))
-
-		request_content := `
-			You are a helpful command-line interpreter. You receive natural language queries
-			and you return the correspondent bash command. And only the command.
-			DO NOT RETURN ANY EXPLANATION OR INSTRUCTION. ONLY RETURN THE COMMAND!
-			You have access to some information about the system you are returning the
-			command for.
-			===
-			OS: {{.OS}}
-			ARCH: {{.ARCH}}
-			CURRENT_DATE: {{.CURRENT_DATE}}
-			===
-			Example:
-			USER: how to list files?
-			ASSISTANT:
-			ls - la
-		`
-
-		var buf bytes.Buffer
-		t := template.Must(template.New(request_content).Parse(request_content))
-
-		err := t.Execute(&buf, map[string]string{
-			"OS":           runtime.GOOS,
-			"ARCH":         runtime.GOARCH,
-			"CURRENT_DATE": time.Now().UTC().Format("2006-01-02T15:04:05Z"),
-		})
+/**
+* Creates the context of a request attempt, bound by the configured timeout
+**/
+func newRequestContext(req_cfg request_config) (context.Context, context.CancelFunc) {
+	if req_cfg.Timeout > 0 {
+		return context.WithTimeout(context.Background(), req_cfg.timeout())
+	}
 
+	return context.WithCancel(context.Background())
+}
+
+func makeGPTcommandRequest(ctx context.Context, provider Provider, history []chat_message, prompt string) tea.Cmd {
+	return func() tea.Msg {
+
+		stream, err := provider.GenerateCommand(ctx, history, prompt)
 		if err != nil {
 			return GPTcommandError{err: err}
 		}
 
-		result := buf.String()
-		result = strings.ReplaceAll(result, "	", "") // remove tabs
+		if ctx.Err() != nil {
+			stream.Close()
+			return GPTcommandError{err: ctx.Err()}
+		}
 
-		req := openai.ChatCompletionRequest{
-			Model: openai.GPT3Dot5Turbo,
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role:    openai.ChatMessageRoleSystem,
-					Content: result,
-				},
-			},
+		return GPTcommandStream{
+			stream: stream,
 		}
+	}
+}
 
-		req.Messages = append(req.Messages, openai.ChatCompletionMessage{
-			Role:    openai.ChatMessageRoleUser,
-			Content: prompt,
-		})
-		resp, err := client.CreateChatCompletion(context.Background(), req)
+/**
+* Waits for the next chunk of the command stream.
+* Needs to be called again after each chunk until the stream is done
+**/
+func waitForGPTcommandChunk(stream completion_stream) tea.Cmd {
+	return func() tea.Msg {
+		content, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			stream.Close()
+			return GPTcommandResult{stream: stream}
+		}
 		if err != nil {
-			return GPTcommandError{err: err}
-
+			stream.Close()
+			return GPTcommandError{stream: stream, err: err}
 		}
 
-		return GPTcommandResult{
-			content: resp.Choices[0].Message.Content,
+		return GPTcommandChunk{
+			stream:  stream,
+			content: content,
 		}
-
-		//// debug
-		// time.Sleep(1 * time.Second)
-		// return GPTcommandError{err: fmt.Errorf("potato is not hot!")}
-		// return GPTcommandResult{
-		// 	content: `ffmpeg -i input.mp4 -vf "select='not(mod(n\,3))'" output.mp4`,
-		// }
 	}
 }
 
-type GPTexplanationResult struct {
+type GPTexplanationStream struct {
+	stream completion_stream
+}
+
+type GPTexplanationChunk struct {
+	stream  completion_stream
 	content string
 }
 
+type GPTexplanationResult struct {
+	stream completion_stream
+}
+
 type GPTexplanationError struct {
-	err error
+	stream completion_stream
+	err    error
 }
 
-func makeGPTexplanationRequest(code string) tea.Cmd {
-	return func() tea.Msg {
-		client := openai.NewClient(os.Getenv("OPENAI_API_KEY"))
+type retryGPTexplanationRequest struct {
+	request_id int
+}
 
-		req := openai.ChatCompletionRequest{
-			Model: openai.GPT3Dot5Turbo,
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role: openai.ChatMessageRoleSystem,
-					Content: `
-						You are a helpful command-line interpreter. You receive a bash command and
-						you return an explanation for it. And only the explanation.
-						Keep the answers simple, concise and short.
-						Explain the different parts of the command in a markdown list, each item is a different piece of the command or argument.
-					`,
-				},
-			},
-		}
+func makeGPTexplanationRequest(ctx context.Context, provider Provider, code string) tea.Cmd {
+	return func() tea.Msg {
 
-		req.Messages = append(req.Messages, openai.ChatCompletionMessage{
-			Role:    openai.ChatMessageRoleUser,
-			Content: code,
-		})
-		resp, err := client.CreateChatCompletion(context.Background(), req)
+		stream, err := provider.ExplainCommand(ctx, code)
 		if err != nil {
 			return GPTexplanationError{err: err}
-
 		}
 
-		return GPTexplanationResult{
-			content: "`" + resp.Choices[0].Message.Content + "`",
+		if ctx.Err() != nil {
+			stream.Close()
+			return GPTexplanationError{err: ctx.Err()}
 		}
 
-		//// debug
-		// time.Sleep(1 * time.Second)
-
-		// return GPTexplanationError{err: fmt.Errorf("potato is not so hot!")}
+		return GPTexplanationStream{
+			stream: stream,
+		}
+	}
+}
 
-		// 		return GPTexplanationResult{
-		// 			content: `
-		// - ffmpeg: the command
-		// - -i: input file
-		// - input.mp4: the input file
-		// - -vf: video filter
-		// - "select='not(mod(n\,3))'": select every third frame out of a lot of frames and you know? I like frames
-		// - output.mp4: the output file
-		// 			`,
-		// 		}
+func waitForGPTexplanationChunk(stream completion_stream) tea.Cmd {
+	return func() tea.Msg {
+		content, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			stream.Close()
+			return GPTexplanationResult{stream: stream}
+		}
+		if err != nil {
+			stream.Close()
+			return GPTexplanationError{stream: stream, err: err}
+		}
 
+		return GPTexplanationChunk{
+			stream:  stream,
+			content: content,
+		}
 	}
 }
 
@@ -849,7 +2312,7 @@ func copyCommandToClipboard(command string) tea.Cmd {
 		err := c.Run()
 
 		if err != nil {
-			return copyCommandToClipboardError{err: fmt.Errorf("❌ error copying to clipboard: %w", stderr.String())}
+			return copyCommandToClipboardError{err: fmt.Errorf("❌ error copying to clipboard: %s", stderr.String())}
 		}
 		return copyCommandToClipboardResult{
 			output: "✅ Command copied to clipboard!",
@@ -883,54 +2346,18 @@ type HistoryFromFileResult struct {
 	history []history_list_item
 }
 
-/**
-* Loads the store.json into a history_list_item struct
-**/
-func LoadStore() []history_list_item {
-	var historyList []history_list_item
-
-	// load json file
-	file, err := os.Open(filepath.Join(getAppConfigDir(), store_file_location))
-	if err != nil {
-		// return empty_result
-		fmt.Printf("Error loading history file: %v\n", err)
-		return []history_list_item{}
-	}
-	defer file.Close()
-
-	decoder := json.NewDecoder(file)
-	err = decoder.Decode(&historyList)
-	if err != nil {
-		fmt.Printf("Error decoding JSON: %v\n", err)
-		return []history_list_item{}
-	}
-	return historyList
+// historyStoreError is returned by the history commands, it is shown on the
+// current screen because the output of the TUI can't be written to
+type historyStoreError struct {
+	err error
 }
 
-/**
-* Saves an array of history_list_item struct into store.json
-**/
-func SaveStore(historyList []history_list_item) {
-
-	// save json file
-	file, err := os.Create(filepath.Join(getAppConfigDir(), store_file_location))
-	if err != nil {
-		fmt.Printf("Error creating history file: %v\n", err)
-		return
-	}
-	defer file.Close()
+func loadHistoryFromFile() tea.Msg {
 
-	encoder := json.NewEncoder(file)
-	err = encoder.Encode(historyList)
+	historyList, err := historyStore().List()
 	if err != nil {
-		fmt.Printf("Error encoding JSON: %v\n", err)
-		return
+		return historyStoreError{err: fmt.Errorf("error loading the history: %w", err)}
 	}
-}
-
-func loadHistoryFromFile() tea.Msg {
-
-	historyList := LoadStore()
 
 	if historyList == nil {
 		return HistoryFromFileResult{
@@ -943,16 +2370,38 @@ func loadHistoryFromFile() tea.Msg {
 	}
 }
 
+/**
+* Saves a new entry in the history along with where it was made
+**/
 func appendToHistory(item history_list_item) tea.Cmd {
 	return func() tea.Msg {
 		item.CreatedAt = time.Now()
+		item.Cwd, _ = os.Getwd()
+		item.Hostname, _ = os.Hostname()
+		item.Shell = filepath.Base(userShell())
+
+		err := historyStore().Append(item)
+		if err != nil {
+			return historyStoreError{err: fmt.Errorf("error saving the history: %w", err)}
+		}
+
+		return nil
 
-		historyList := LoadStore()
+	}
+}
 
-		if historyList != nil {
-			historyList = append(historyList, item)
+/**
+* Changes the history entry, it does nothing when the response has no entry
+**/
+func updateHistory(id string, update func(item *history_list_item)) tea.Cmd {
+	return func() tea.Msg {
+		if id == "" {
+			return nil
+		}
 
-			SaveStore(historyList)
+		err := historyStore().Update(id, update)
+		if err != nil {
+			return historyStoreError{err: fmt.Errorf("error saving the history: %w", err)}
 		}
 
 		return nil
@@ -960,18 +2409,62 @@ func appendToHistory(item history_list_item) tea.Cmd {
 	}
 }
 
-func storeExplanationInHistory(explanation string) tea.Cmd {
+func storeExplanationInHistory(id string, explanation string) tea.Cmd {
+	return updateHistory(id, func(item *history_list_item) {
+		item.ResponseExplanation = explanation
+	})
+}
+
+func storeRunResultInHistory(id string, result run_result) tea.Cmd {
+	return updateHistory(id, func(item *history_list_item) {
+		item.Ran = true
+		item.LastRun = historyRunResult(result)
+	})
+}
+
+func deleteFromHistory(id string) tea.Cmd {
 	return func() tea.Msg {
-		historyList := LoadStore()
+		err := historyStore().Delete(id)
+		if err != nil {
+			return historyStoreError{err: fmt.Errorf("error saving the history: %w", err)}
+		}
 
-		if historyList != nil {
-			lastItem := historyList[len(historyList)-1]
+		return nil
+
+	}
+}
 
-			lastItem.ResponseExplanation = explanation
+func storeEditInHistory(id string, command string) tea.Cmd {
+	return updateHistory(id, func(item *history_list_item) {
+		if !item.Edited {
+			item.OriginalCode = item.ResponseCode
+		}
 
-			historyList[len(historyList)-1] = lastItem
+		item.Edited = true
+		item.ResponseCode = command
+		// the explanation was about the generated command
+		item.ResponseExplanation = ""
+	})
+}
 
-			SaveStore(historyList)
+/**
+* Replaces the saved command of the entry from the history screen. Unlike
+* the edits before running a command, the previous command is not kept,
+* not even in the previous versions of the entry in the history file
+**/
+func replaceCommandInHistory(id string, command string) tea.Cmd {
+	return func() tea.Msg {
+		err := historyStore().Update(id, func(item *history_list_item) {
+			item.ResponseCode = command
+			item.OriginalCode = ""
+			// the explanation was about the previous command
+			item.ResponseExplanation = ""
+		})
+		if err == nil {
+			err = historyStore().Compact()
+		}
+		if err != nil {
+			return historyStoreError{err: fmt.Errorf("error saving the history: %w", err)}
 		}
 
 		return nil
@@ -982,7 +2475,7 @@ func storeExplanationInHistory(explanation string) tea.Cmd {
 func getAppConfigDir() string {
 	appConfigDir, err := os.UserConfigDir()
 	if err != nil {
-		fmt.Printf("Error getting user config dir: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error getting user config dir: %v\n", err)
 		return ""
 	}
 
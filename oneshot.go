@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// max bytes of piped stdin sent to the model as sample data
+const stdin_sample_limit = 4 * 1024
+
+// one_shot_options are the flags of the non interactive mode
+type one_shot_options struct {
+	explain     bool
+	run         bool
+	json_output bool
+	force       bool
+	// stdin_sample is the beginning of the data piped to clai, the generated
+	// command is shaped to read it. stdin still yields all of it for -run
+	stdin_sample stdin_sample
+	stdin        io.Reader
+}
+
+// stdin_sample is the beginning of the data piped to clai
+type stdin_sample struct {
+	data      []byte
+	truncated bool
+}
+
+// one_shot_result is printed to stdout with the -json flag
+type one_shot_result struct {
+	Prompt        string      `json:"prompt,omitempty"`
+	Command       string      `json:"command"`
+	Description   string      `json:"description,omitempty"`
+	Risk          string      `json:"risk,omitempty"`
+	RequiredTools []string    `json:"required_tools,omitempty"`
+	Explanation   string      `json:"explanation,omitempty"`
+	Run           *run_result `json:"run,omitempty"`
+}
+
+/**
+* Returns true when stdin is piped or redirected instead of being a terminal
+**/
+func isStdinPiped() bool {
+	stat, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+
+	return stat.Mode()&os.ModeCharDevice == 0
+}
+
+/**
+* Reads the beginning of stdin to use it as sample data in the prompt and
+* returns a reader that still yields the whole input
+**/
+func readStdinSample(stdin io.Reader) (stdin_sample, io.Reader, error) {
+	data, err := io.ReadAll(io.LimitReader(stdin, stdin_sample_limit+1))
+	if err != nil {
+		return stdin_sample{}, stdin, err
+	}
+
+	sample := stdin_sample{data: data}
+	if len(data) > stdin_sample_limit {
+		sample.data = data[:stdin_sample_limit]
+		sample.truncated = true
+	}
+
+	return sample, io.MultiReader(bytes.NewReader(data), stdin), nil
+}
+
+/**
+* Adds the sample of the piped data to the prompt so the command is shaped
+* to read it from stdin
+**/
+func withStdinSample(prompt string, sample stdin_sample) string {
+	if len(sample.data) == 0 {
+		return prompt
+	}
+
+	data := sample.data
+	// the sample may end in the middle of a multi-byte character
+	for i := 0; i < utf8.UTFMax && len(data) > 0 && !utf8.Valid(data); i++ {
+		data = data[:len(data)-1]
+	}
+
+	if !utf8.Valid(data) {
+		return prompt + "\n\nThe command reads binary data from stdin."
+	}
+
+	description := "The command reads this data from stdin"
+	if sample.truncated {
+		description += fmt.Sprintf(", only the first %d bytes are shown", len(data))
+	}
+
+	return prompt + "\n\n" + description + ":\n```\n" + strings.TrimRight(string(data), "\n") + "\n```"
+}
+
+/**
+* Generates the command for the prompt without starting the TUI and prints
+* only the command to stdout so it can be used from scripts and other tools.
+* Returns the exit code of the process
+**/
+func runOneShot(cfg app_config, provider Provider, prompt string, opts one_shot_options) int {
+	prompt = strings.TrimSpace(prompt)
+	if prompt == "" {
+		fmt.Fprintln(os.Stderr, "❌ Prompt cannot be empty")
+		return 1
+	}
+
+	request_prompt := withStdinSample(prompt, opts.stdin_sample)
+	started_at := time.Now()
+
+	raw_response, err := completeWithRetries(cfg.Command, func(ctx context.Context) (completion_stream, error) {
+		return provider.GenerateCommand(ctx, nil, request_prompt)
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "❌ "+friendlyRequestError(err))
+		return 1
+	}
+	latency := time.Since(started_at).Seconds()
+
+	response := parseCommandResponse(raw_response)
+	command := response.Command
+	if command == "" {
+		fmt.Fprintln(os.Stderr, "❌ The response has no command")
+		return 1
+	}
+
+	result := one_shot_result{
+		Prompt:        prompt,
+		Command:       command,
+		Description:   response.Description,
+		Risk:          response.Risk,
+		RequiredTools: response.RequiredTools,
+	}
+
+	if opts.explain {
+		explanation, err := completeWithRetries(cfg.Explanation, func(ctx context.Context) (completion_stream, error) {
+			return provider.ExplainCommand(ctx, command)
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "❌ "+friendlyRequestError(err))
+			return 1
+		}
+		result.Explanation = strings.TrimSpace(explanation)
+	}
+
+	exit_code := 0
+
+	// dangerous commands are never run unattended unless forced
+	if opts.run && !opts.force {
+		if risks := analyzeCommandRisk(command); len(risks) > 0 {
+			fmt.Fprintln(os.Stderr, "⚠︎ Not running the command because it looks dangerous:")
+			for _, risk := range risks {
+				fmt.Fprintln(os.Stderr, "  • "+risk)
+			}
+			fmt.Fprintln(os.Stderr, "Use -force to run it anyway")
+			opts.run = false
+			exit_code = 1
+		}
+	}
+
+	if !opts.json_output {
+		if opts.run {
+			// the command output goes to stdout so show what is running on stderr
+			fmt.Fprintln(os.Stderr, "$ "+command)
+		} else {
+			fmt.Println(command)
+		}
+
+		if opts.explain {
+			fmt.Fprintln(os.Stderr, "\n"+renderExplanationResultViewport(result.Explanation))
+		}
+	}
+
+	if opts.run {
+		// with -json the output is only recorded in the result
+		var stdout, stderr io.Writer = os.Stdout, os.Stderr
+		if opts.json_output {
+			stdout, stderr = io.Discard, io.Discard
+		}
+
+		c := exec.Command(userShell(), "-c", command)
+		c.Stdin = os.Stdin
+		if opts.stdin != nil {
+			c.Stdin = opts.stdin
+		}
+
+		run, err := runAndRecord(c, stdout, stderr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Error running command: %v\n", err)
+		}
+
+		result.Run = &run
+		exit_code = run.ExitCode
+		if !run.succeeded() && exit_code <= 0 {
+			exit_code = 1
+		}
+	}
+
+	initAppConfigDir()
+	item := history_list_item{
+		PromptText:          result.Prompt,
+		ResponseCode:        result.Command,
+		ResponseExplanation: result.Explanation,
+		Model:               cfg.Command.Model,
+		Latency:             latency,
+	}
+	if result.Run != nil {
+		item.Ran = true
+		item.LastRun = historyRunResult(*result.Run)
+	}
+	if msg, ok := appendToHistory(item)().(historyStoreError); ok {
+		fmt.Fprintln(os.Stderr, "❌ "+msg.err.Error())
+	}
+
+	if opts.json_output {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(result)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Error encoding JSON: %v\n", err)
+			return 1
+		}
+	}
+
+	return exit_code
+}
+
+/**
+* Explains an existing command, eg: one from a README or the shell history,
+* and prints the rendered explanation. Returns the exit code of the process
+**/
+func runExplain(cfg app_config, provider Provider, command string, json_output bool) int {
+	command = strings.TrimSpace(command)
+
+	explanation, err := completeWithRetries(cfg.Explanation, func(ctx context.Context) (completion_stream, error) {
+		return provider.ExplainCommand(ctx, command)
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "❌ "+friendlyRequestError(err))
+		return 1
+	}
+
+	result := one_shot_result{
+		Command:     command,
+		Explanation: strings.TrimSpace(explanation),
+	}
+
+	initAppConfigDir()
+	saved := appendToHistory(history_list_item{
+		PromptText:          explainPromptText(command),
+		ResponseCode:        result.Command,
+		ResponseExplanation: result.Explanation,
+		Model:               cfg.Explanation.Model,
+	})()
+	if msg, ok := saved.(historyStoreError); ok {
+		fmt.Fprintln(os.Stderr, "❌ "+msg.err.Error())
+	}
+
+	if json_output {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(result)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Error encoding JSON: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Print(renderExplanationResultViewport(result.Explanation))
+
+	return 0
+}
+
+/**
+* History title of an explained command that was not generated by clai
+**/
+func explainPromptText(command string) string {
+	return "Explain: " + command
+}
+
+/**
+* Blocking version of the request flow used by the TUI: reads the whole
+* completion and retries with backoff on temporary errors
+**/
+func completeWithRetries(req_cfg request_config, request func(ctx context.Context) (completion_stream, error)) (string, error) {
+	for attempt := 0; ; attempt++ {
+		content, err := completeAttempt(req_cfg, request)
+		if err == nil {
+			return content, nil
+		}
+
+		if !isRetryableRequestError(err) || attempt >= req_cfg.MaxRetries {
+			return "", err
+		}
+
+		fmt.Fprintf(os.Stderr, "retrying (%d/%d)…\n", attempt+1, req_cfg.MaxRetries)
+		time.Sleep(retryBackoff(attempt + 1))
+	}
+}
+
+func completeAttempt(req_cfg request_config, request func(ctx context.Context) (completion_stream, error)) (string, error) {
+	ctx, cancel := newRequestContext(req_cfg)
+	defer cancel()
+
+	stream, err := request(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return readCompletion(stream)
+}
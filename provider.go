@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Provider is a LLM backend that is able to generate commands from natural
+// language prompts and to explain existing commands.
+// The responses are streamed so they can be rendered as they are produced.
+// The history of GenerateCommand holds the previous prompts and commands when
+// the command is being refined, it is empty for a new command.
+// The command responses are JSON objects, see parseCommandResponse.
+// GenerateAlternatives returns up to n different candidate commands at once.
+type Provider interface {
+	GenerateCommand(ctx context.Context, history []chat_message, prompt string) (completion_stream, error)
+	GenerateAlternatives(ctx context.Context, history []chat_message, prompt string, n int) ([]command_response, error)
+	ExplainCommand(ctx context.Context, command string) (completion_stream, error)
+}
+
+// chat_message is a turn of a conversation with the model
+type chat_message struct {
+	// Role is "user" or "assistant"
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// completion_stream yields the chunks of a completion as they are produced.
+// Recv returns io.EOF once the completion is done and Close aborts it.
+type completion_stream interface {
+	Recv() (string, error)
+	Close()
+}
+
+/**
+* Reads the whole stream and returns the complete response
+**/
+func readCompletion(stream completion_stream) (string, error) {
+	defer stream.Close()
+
+	var content strings.Builder
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return content.String(), nil
+		}
+		if err != nil {
+			return content.String(), err
+		}
+		content.WriteString(chunk)
+	}
+}
+
+/**
+* Parses the raw responses and removes the empty commands and the
+* duplicates, commands only differing in whitespace are the same
+**/
+func uniqueCommandResponses(raw_responses []string) []command_response {
+	seen := map[string]bool{}
+	var unique []command_response
+
+	for _, raw := range raw_responses {
+		response := parseCommandResponse(raw)
+		key := strings.Join(strings.Fields(response.Command), " ")
+		if key == "" || seen[key] {
+			continue
+		}
+
+		seen[key] = true
+		unique = append(unique, response)
+	}
+
+	return unique
+}
+
+/**
+* Returns the provider selected in the config
+**/
+func newProvider(cfg app_config) (Provider, error) {
+	switch cfg.Provider {
+	case "", "openai":
+		return newOpenAIProvider(cfg), nil
+	case "ollama":
+		return newOllamaProvider(cfg), nil
+	case "fake":
+		return newFakeProvider(), nil
+	default:
+		return nil, fmt.Errorf("unknown provider %q", cfg.Provider)
+	}
+}
+
+const command_system_prompt = `
+	You are a helpful command-line interpreter. You receive natural language queries
+	and you return the correspondent bash command.
+	You have access to some information about the system you are returning the
+	command for.
+	===
+	OS: {{.OS}}
+	ARCH: {{.ARCH}}
+	CURRENT_DATE: {{.CURRENT_DATE}}
+	{{range .CONTEXT}}{{.}}
+	{{end}}===
+	ALWAYS ANSWER WITH A JSON OBJECT AND NOTHING ELSE, NO MARKDOWN. The fields are:
+	- command: the command, ready to run
+	- description: what the command does, in one short sentence
+	- risk: "low", "medium" or "high". high when the command deletes or overwrites data, changes the system or needs superuser privileges
+	- required_tools: the programs the command runs
+	Example:
+	USER: how to list files?
+	ASSISTANT:
+	{"command": "ls -la", "description": "Lists all the files with their details", "risk": "low", "required_tools": ["ls"]}
+`
+
+const explanation_system_prompt = `
+	You are a helpful command-line interpreter. You receive a bash command and
+	you return an explanation for it. And only the explanation.
+	Keep the answers simple, concise and short.
+	Explain the different parts of the command in a markdown list, each item is a different piece of the command or argument.
+`
+
+/**
+* Renders the system prompt used for command generation with the
+* information about the system the command is for
+**/
+func buildCommandSystemPrompt(ctx_cfg context_config, prompt string) (string, error) {
+	var buf bytes.Buffer
+	t := template.Must(template.New("command_system_prompt").Parse(command_system_prompt))
+
+	err := t.Execute(&buf, map[string]interface{}{
+		"OS":           runtime.GOOS,
+		"ARCH":         runtime.GOARCH,
+		"CURRENT_DATE": time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+		"CONTEXT":      collectShellContext(ctx_cfg, prompt),
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	result := buf.String()
+	result = strings.ReplaceAll(result, "	", "") // remove tabs
+
+	return result, nil
+}
+
+func buildExplanationSystemPrompt() string {
+	return strings.ReplaceAll(explanation_system_prompt, "	", "")
+}
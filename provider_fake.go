@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeProvider returns canned responses without calling any API.
+// Useful to work on the screens and for tests: set "provider": "fake"
+// in config.json or CLAI_PROVIDER=fake. The zero value answers right
+// away, for tests
+type fakeProvider struct {
+	// latency is the time it takes to get the first byte of a response
+	latency time.Duration
+	// chunk_delay is the time between the chunks of a streamed response
+	chunk_delay time.Duration
+}
+
+/**
+* Returns the fake provider used by the app, it is as slow as a real one
+* so the loading states can be seen
+**/
+func newFakeProvider() fakeProvider {
+	return fakeProvider{
+		latency:     1 * time.Second,
+		chunk_delay: 50 * time.Millisecond,
+	}
+}
+
+// fakeStream streams the canned response word by word
+type fakeStream struct {
+	ctx        context.Context
+	chunks     []string
+	delay      time.Duration
+	closed     chan struct{}
+	close_once sync.Once
+}
+
+func newFakeStream(ctx context.Context, content string, delay time.Duration) *fakeStream {
+	return &fakeStream{
+		ctx:    ctx,
+		chunks: strings.SplitAfter(content, " "),
+		delay:  delay,
+		closed: make(chan struct{}),
+	}
+}
+
+func (s *fakeStream) Recv() (string, error) {
+	if len(s.chunks) == 0 {
+		return "", io.EOF
+	}
+
+	select {
+	case <-s.ctx.Done():
+		return "", s.ctx.Err()
+	case <-s.closed:
+		return "", errors.New("stream closed")
+	case <-time.After(s.delay):
+	}
+
+	chunk := s.chunks[0]
+	s.chunks = s.chunks[1:]
+
+	return chunk, nil
+}
+
+func (s *fakeStream) Close() {
+	s.close_once.Do(func() { close(s.closed) })
+}
+
+// wait simulates the time it takes to get the first byte of a response
+func (p fakeProvider) wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(p.latency):
+		return nil
+	}
+}
+
+func (p fakeProvider) GenerateCommand(ctx context.Context, history []chat_message, prompt string) (completion_stream, error) {
+	err := p.wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return newFakeStream(ctx, `{"command": "ffmpeg -i input.mp4 -vf \"select='not(mod(n\\,3))'\" output.mp4", "description": "Keeps one frame out of three of the video", "risk": "low", "required_tools": ["ffmpeg"]}`, p.chunk_delay), nil
+}
+
+func (p fakeProvider) GenerateAlternatives(ctx context.Context, history []chat_message, prompt string, n int) ([]command_response, error) {
+	err := p.wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// plain commands, as returned by models that don't follow the JSON format
+	commands := uniqueCommandResponses([]string{
+		`ffmpeg -i input.mp4 -vf "select='not(mod(n\,3))'" output.mp4`,
+		`ffmpeg -i input.mp4 -vf "select='not(mod(n\,3))'"   output.mp4`,
+		`ffmpeg -i input.mp4 -vf "select='not(mod(n\,3))',setpts=N/FRAME_RATE/TB" output.mp4`,
+		`ffmpeg -i input.mp4 -r 10 output.mp4`,
+	})
+	if n < len(commands) {
+		commands = commands[:n]
+	}
+
+	return commands, nil
+}
+
+func (p fakeProvider) ExplainCommand(ctx context.Context, command string) (completion_stream, error) {
+	err := p.wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return newFakeStream(ctx, `
+- ffmpeg: the command
+- -i: input file
+- input.mp4: the input file
+- -vf: video filter
+- "select='not(mod(n\,3))'": select every third frame
+- output.mp4: the output file
+`, p.chunk_delay), nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestCommandRequestWithFakeProvider(t *testing.T) {
+	m := initialModel(defaultConfig(), fakeProvider{})
+
+	update := func(msg tea.Msg) tea.Cmd {
+		next, cmd := updateSelectedScreen(msg, m)
+		m = next.(model)
+		return cmd
+	}
+
+	m.prompt_textarea.SetValue("how to keep one frame out of three")
+	cmd := update(tea.KeyMsg{Type: tea.KeyCtrlS})
+	if !m.is_making_gpt_code_request || cmd == nil {
+		t.Fatal("expected the command request to start")
+	}
+
+	// makeGPTcommandRequest returns the stream, then waitForGPTcommandChunk
+	// returns its chunks one by one until the result
+	msg := cmd()
+	if _, ok := msg.(GPTcommandStream); !ok {
+		t.Fatalf("expected a GPTcommandStream, got %T", msg)
+	}
+
+	for chunks := 0; ; chunks++ {
+		if chunks > 1000 {
+			t.Fatal("the stream never ended")
+		}
+
+		cmd = update(msg)
+		if _, ok := msg.(GPTcommandResult); ok {
+			break
+		}
+		if cmd == nil {
+			t.Fatalf("expected the next chunk after %T", msg)
+		}
+
+		msg = cmd()
+		if err, ok := msg.(GPTcommandError); ok {
+			t.Fatal(err.err)
+		}
+	}
+
+	if m.selected_screen != "prompt_response_screen" {
+		t.Errorf("expected the response screen, got %s", m.selected_screen)
+	}
+	if m.is_making_gpt_code_request {
+		t.Error("expected the request to be done")
+	}
+
+	expected := `ffmpeg -i input.mp4 -vf "select='not(mod(n\,3))'" output.mp4`
+	if m.response_code_text != expected {
+		t.Errorf("expected the command %q, got %q", expected, m.response_code_text)
+	}
+	if m.response_description == "" {
+		t.Error("expected the description of the command")
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ollamaProvider talks to a local Ollama style HTTP API (/api/chat) so that
+// clAI works offline, without an OpenAI key
+type ollamaProvider struct {
+	base_url    string
+	http_client *http.Client
+	command     request_config
+	explanation request_config
+	context     context_config
+}
+
+type ollama_message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type ollama_options struct {
+	Temperature float32 `json:"temperature,omitempty"`
+	NumPredict  int     `json:"num_predict,omitempty"`
+}
+
+type ollama_chat_request struct {
+	Model    string           `json:"model"`
+	Messages []ollama_message `json:"messages"`
+	Stream   bool             `json:"stream"`
+	// Format "json" makes the model answer with a JSON object
+	Format  string         `json:"format,omitempty"`
+	Options ollama_options `json:"options"`
+}
+
+type ollama_chat_response struct {
+	Message ollama_message `json:"message"`
+	Done    bool           `json:"done"`
+	Error   string         `json:"error"`
+}
+
+func newOllamaProvider(cfg app_config) ollamaProvider {
+	return ollamaProvider{
+		base_url:    strings.TrimRight(cfg.Ollama.BaseURL, "/"),
+		http_client: &http.Client{},
+		command:     cfg.Command,
+		explanation: cfg.Explanation,
+		context:     cfg.Context,
+	}
+}
+
+// ollamaStream reads the newline delimited json objects streamed by /api/chat
+type ollamaStream struct {
+	body    io.ReadCloser
+	decoder *json.Decoder
+	done    bool
+}
+
+func (s *ollamaStream) Recv() (string, error) {
+	if s.done {
+		return "", io.EOF
+	}
+
+	var chat_response ollama_chat_response
+	err := s.decoder.Decode(&chat_response)
+	if err != nil {
+		return "", err
+	}
+
+	if chat_response.Error != "" {
+		return "", fmt.Errorf("ollama request failed: %s", chat_response.Error)
+	}
+
+	s.done = chat_response.Done
+
+	return chat_response.Message.Content, nil
+}
+
+func (s *ollamaStream) Close() {
+	s.body.Close()
+}
+
+func (p ollamaProvider) GenerateCommand(ctx context.Context, history []chat_message, prompt string) (completion_stream, error) {
+	system_prompt, err := buildCommandSystemPrompt(p.context, prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.stream(ctx, p.command, system_prompt, history, prompt, "json")
+}
+
+/**
+* The chat API has no n parameter so the command is generated n times,
+* one request after the other as local servers usually run one at a time
+**/
+func (p ollamaProvider) GenerateAlternatives(ctx context.Context, history []chat_message, prompt string, n int) ([]command_response, error) {
+	var raw_responses []string
+
+	for i := 0; i < n; i++ {
+		stream, err := p.GenerateCommand(ctx, history, prompt)
+		if err != nil {
+			return nil, err
+		}
+
+		raw, err := readCompletion(stream)
+		if err != nil {
+			return nil, err
+		}
+		raw_responses = append(raw_responses, raw)
+	}
+
+	return uniqueCommandResponses(raw_responses), nil
+}
+
+func (p ollamaProvider) ExplainCommand(ctx context.Context, command string) (completion_stream, error) {
+	return p.stream(ctx, p.explanation, buildExplanationSystemPrompt(), nil, command, "")
+}
+
+func (p ollamaProvider) stream(ctx context.Context, req_cfg request_config, system_prompt string, history []chat_message, user_prompt string, format string) (completion_stream, error) {
+	messages := []ollama_message{{Role: "system", Content: system_prompt}}
+	for _, message := range history {
+		messages = append(messages, ollama_message{Role: message.Role, Content: message.Content})
+	}
+	messages = append(messages, ollama_message{Role: "user", Content: user_prompt})
+
+	body, err := json.Marshal(ollama_chat_request{
+		Model:    req_cfg.Model,
+		Messages: messages,
+		Stream:   true,
+		Format:   format,
+		Options: ollama_options{
+			Temperature: req_cfg.Temperature,
+			NumPredict:  req_cfg.MaxTokens,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.base_url+"/api/chat", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.http_client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+
+		var chat_response ollama_chat_response
+		json.NewDecoder(resp.Body).Decode(&chat_response)
+
+		return nil, http_status_error{status_code: resp.StatusCode, message: chat_response.Error}
+	}
+
+	return &ollamaStream{
+		body:    resp.Body,
+		decoder: json.NewDecoder(resp.Body),
+	}, nil
+}
+
+/**
+* Checks if the local server is up by hitting the /api/tags endpoint
+**/
+func checkOllamaServer(base_url string) error {
+	client := http.Client{Timeout: 2 * time.Second}
+
+	resp, err := client.Get(strings.TrimRight(base_url, "/") + "/api/tags")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
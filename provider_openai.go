@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// openAIProvider talks to the OpenAI chat completions API or any
+// OpenAI compatible API set in the base url
+type openAIProvider struct {
+	client      *openai.Client
+	command     request_config
+	explanation request_config
+	context     context_config
+}
+
+// openAIStream adapts the go-openai stream to a completion_stream
+type openAIStream struct {
+	stream *openai.ChatCompletionStream
+}
+
+func (s openAIStream) Recv() (string, error) {
+	resp, err := s.stream.Recv()
+	if err != nil {
+		return "", err
+	}
+
+	if len(resp.Choices) == 0 {
+		return "", nil
+	}
+
+	// the command responses come as the arguments of a function call
+	delta := resp.Choices[0].Delta
+	if delta.FunctionCall != nil {
+		return delta.Content + delta.FunctionCall.Arguments, nil
+	}
+
+	return delta.Content, nil
+}
+
+func (s openAIStream) Close() {
+	s.stream.Close()
+}
+
+func newOpenAIProvider(cfg app_config) openAIProvider {
+	client_config := openai.DefaultConfig(os.Getenv("OPENAI_API_KEY"))
+	if cfg.OpenAI.BaseURL != "" {
+		client_config.BaseURL = cfg.OpenAI.BaseURL
+	}
+
+	return openAIProvider{
+		client:      openai.NewClientWithConfig(client_config),
+		command:     cfg.Command,
+		explanation: cfg.Explanation,
+		context:     cfg.Context,
+	}
+}
+
+func (p openAIProvider) GenerateCommand(ctx context.Context, history []chat_message, prompt string) (completion_stream, error) {
+	req, err := p.commandRequest(history, prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.stream(ctx, req)
+}
+
+/**
+* Asks for n choices in a single request. Some OpenAI compatible APIs
+* ignore n and return a single choice
+**/
+func (p openAIProvider) GenerateAlternatives(ctx context.Context, history []chat_message, prompt string, n int) ([]command_response, error) {
+	req, err := p.commandRequest(history, prompt)
+	if err != nil {
+		return nil, err
+	}
+	req.N = n
+
+	resp, err := p.client.CreateChatCompletion(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw_responses []string
+	for _, choice := range resp.Choices {
+		raw := choice.Message.Content
+		if choice.Message.FunctionCall != nil {
+			raw += choice.Message.FunctionCall.Arguments
+		}
+		raw_responses = append(raw_responses, raw)
+	}
+
+	return uniqueCommandResponses(raw_responses), nil
+}
+
+func (p openAIProvider) ExplainCommand(ctx context.Context, command string) (completion_stream, error) {
+	return p.stream(ctx, p.request(p.explanation, buildExplanationSystemPrompt(), nil, command))
+}
+
+/**
+* The command request forces a function call so the response is a JSON
+* object matching command_response
+**/
+func (p openAIProvider) commandRequest(history []chat_message, prompt string) (openai.ChatCompletionRequest, error) {
+	system_prompt, err := buildCommandSystemPrompt(p.context, prompt)
+	if err != nil {
+		return openai.ChatCompletionRequest{}, err
+	}
+
+	req := p.request(p.command, system_prompt, history, prompt)
+	req.Functions = []openai.FunctionDefinition{
+		{
+			Name:        command_response_function,
+			Description: "Returns the command for the user's request",
+			Parameters:  json.RawMessage(command_response_schema),
+		},
+	}
+	req.FunctionCall = openai.FunctionCall{Name: command_response_function}
+
+	return req, nil
+}
+
+func (p openAIProvider) request(req_cfg request_config, system_prompt string, history []chat_message, user_prompt string) openai.ChatCompletionRequest {
+	return openai.ChatCompletionRequest{
+		Model:       req_cfg.Model,
+		Temperature: req_cfg.Temperature,
+		MaxTokens:   req_cfg.MaxTokens,
+		Messages:    p.messages(system_prompt, history, user_prompt),
+	}
+}
+
+func (p openAIProvider) stream(ctx context.Context, req openai.ChatCompletionRequest) (completion_stream, error) {
+	stream, err := p.client.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return openAIStream{stream: stream}, nil
+}
+
+func (p openAIProvider) messages(system_prompt string, history []chat_message, user_prompt string) []openai.ChatCompletionMessage {
+	messages := []openai.ChatCompletionMessage{
+		{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: system_prompt,
+		},
+	}
+
+	for _, message := range history {
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    message.Role,
+			Content: message.Content,
+		})
+	}
+
+	messages = append(messages, openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: user_prompt,
+	})
+
+	return messages
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// http_status_error is returned by the providers when the API responds
+// with an error status code
+type http_status_error struct {
+	status_code int
+	message     string
+}
+
+func (e http_status_error) Error() string {
+	return fmt.Sprintf("status code: %d, message: %s", e.status_code, e.message)
+}
+
+type request_error_kind int
+
+const (
+	request_error_unknown request_error_kind = iota
+	request_error_auth
+	request_error_quota
+	request_error_rate_limit
+	request_error_server
+	request_error_network
+	request_error_timeout
+)
+
+/**
+* Works out what kind of failure a request error is, regardless of the provider
+**/
+func classifyRequestError(err error) request_error_kind {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return request_error_timeout
+	}
+
+	status_code := 0
+
+	var api_err *openai.APIError
+	var req_err *openai.RequestError
+	var status_err http_status_error
+
+	switch {
+	case errors.As(err, &api_err):
+		status_code = api_err.HTTPStatusCode
+		if api_err.Type == "insufficient_quota" || api_err.Code == "insufficient_quota" {
+			return request_error_quota
+		}
+	case errors.As(err, &req_err):
+		status_code = req_err.HTTPStatusCode
+	case errors.As(err, &status_err):
+		status_code = status_err.status_code
+	}
+
+	switch {
+	case status_code == 401 || status_code == 403:
+		return request_error_auth
+	case status_code == 429:
+		return request_error_rate_limit
+	case status_code >= 500:
+		return request_error_server
+	}
+
+	var net_err net.Error
+	if errors.As(err, &net_err) {
+		if net_err.Timeout() {
+			return request_error_timeout
+		}
+		return request_error_network
+	}
+
+	return request_error_unknown
+}
+
+/**
+* Rate limits, server errors and connection problems are usually temporary
+* so the request is worth retrying
+**/
+func isRetryableRequestError(err error) bool {
+	switch classifyRequestError(err) {
+	case request_error_rate_limit, request_error_server, request_error_network, request_error_timeout:
+		return true
+	default:
+		return false
+	}
+}
+
+/**
+* Exponential backoff for the retry attempt: 1s, 2s, 4s... up to 30s
+**/
+func retryBackoff(attempt int) time.Duration {
+	backoff := time.Second << (attempt - 1)
+	if backoff <= 0 || backoff > 30*time.Second {
+		backoff = 30 * time.Second
+	}
+	return backoff
+}
+
+/**
+* Turns a request error into a message the user can act upon
+**/
+func friendlyRequestError(err error) string {
+	switch classifyRequestError(err) {
+	case request_error_auth:
+		return "Authentication failed, check your API key: " + err.Error()
+	case request_error_quota:
+		return "Quota exceeded, check your plan and billing details: " + err.Error()
+	case request_error_rate_limit:
+		return "Rate limited, wait a bit and try again: " + err.Error()
+	case request_error_server:
+		return "The server is having problems, try again later: " + err.Error()
+	case request_error_network:
+		return "Network error, check your connection or the base URL: " + err.Error()
+	case request_error_timeout:
+		return "The request timed out, try again or increase the timeout in the configs"
+	default:
+		return err.Error()
+	}
+}
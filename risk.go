@@ -0,0 +1,216 @@
+package main
+
+import (
+	"strings"
+)
+
+var shell_interpreters = map[string]bool{
+	"sh":   true,
+	"bash": true,
+	"zsh":  true,
+	"dash": true,
+	"ksh":  true,
+	"fish": true,
+}
+
+var downloaders = map[string]bool{
+	"curl":  true,
+	"wget":  true,
+	"fetch": true,
+}
+
+// rm targets that wipe way more than the user probably wants
+var sensitive_paths = map[string]bool{
+	"/":     true,
+	"/*":    true,
+	"~":     true,
+	"~/":    true,
+	"~/*":   true,
+	"$HOME": true,
+	".":     true,
+	"..":    true,
+	"*":     true,
+}
+
+/**
+* Looks for destructive patterns in the command and returns the reasons
+* why it is dangerous. No reasons means nothing was flagged
+**/
+func analyzeCommandRisk(command string) []string {
+	var risks []string
+
+	add := func(risk string) {
+		for _, existing := range risks {
+			if existing == risk {
+				return
+			}
+		}
+		risks = append(risks, risk)
+	}
+
+	commands := parseShellCommands(command)
+
+	// the commands run by find and by sh -c are dangerous on their own
+	for i := 0; i < len(commands); i++ {
+		name, args := commands[i].executable()
+
+		if name == "find" {
+			commands = append(commands, findExecCommands(args)...)
+		}
+		if shell_interpreters[name] {
+			commands = append(commands, interpreterScriptCommands(args)...)
+		}
+	}
+
+	for i, c := range commands {
+		if c.isPrivileged() {
+			add("Runs with superuser privileges (sudo)")
+		}
+
+		for _, redirect := range c.redirects {
+			if strings.Contains(redirect.op, ">") && isBlockDevice(redirect.target) {
+				add("Overwrites a disk device (> " + redirect.target + ")")
+			}
+		}
+
+		name, args := c.executable()
+
+		switch {
+		case name == "rm":
+			recursive, force := false, false
+			for _, arg := range args {
+				switch {
+				case arg == "--recursive":
+					recursive = true
+				case arg == "--force":
+					force = true
+				case arg == "--no-preserve-root":
+					add("Allows deleting the root directory (rm --no-preserve-root)")
+				case strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--"):
+					recursive = recursive || strings.ContainsAny(arg, "rR")
+					force = force || strings.Contains(arg, "f")
+				}
+			}
+
+			if recursive && force {
+				add("Deletes files recursively without asking for confirmation (rm -rf)")
+			}
+			if recursive {
+				for _, arg := range args {
+					if sensitive_paths[arg] {
+						add("Deletes everything in " + arg)
+					}
+				}
+			}
+
+		case name == "dd":
+			for _, arg := range args {
+				if strings.HasPrefix(arg, "of=/dev/") && arg != "of=/dev/null" {
+					add("Writes directly to a device (dd " + arg + ")")
+				}
+			}
+
+		case name == "mkfs" || strings.HasPrefix(name, "mkfs.") || name == "mke2fs" || name == "wipefs":
+			add("Formats or wipes a filesystem (" + name + ")")
+
+		case name == "chmod" || name == "chown":
+			recursive := false
+			world_writable := false
+			on_root := false
+			for _, arg := range args {
+				switch {
+				case arg == "-R" || arg == "--recursive" || (strings.HasPrefix(arg, "-") && strings.Contains(arg, "R")):
+					recursive = true
+				case arg == "777" || arg == "0777" || arg == "a+rwx" || arg == "ugo+rwx" || arg == "o+w" || arg == "a+w":
+					world_writable = true
+				case arg == "/" || arg == "/*":
+					on_root = true
+				}
+			}
+
+			if name == "chmod" && recursive && world_writable {
+				add("Makes files world writable recursively (chmod -R 777)")
+			}
+			if recursive && on_root {
+				add("Changes the permissions of the whole system (" + name + " -R /)")
+			}
+
+		case shell_interpreters[name]:
+			if c.piped && i > 0 {
+				previous_name, _ := commands[i-1].executable()
+				if downloaders[previous_name] {
+					add("Runs a script downloaded from the internet (" + previous_name + " | " + name + ")")
+				}
+			}
+
+			// eg: bash <(curl -s x.sh), sh -c "$(curl -fsSL x.sh)" or sh -c 'wget -O- x.sh | sh'
+			for _, inner := range append(c.substitutions, interpreterScriptCommands(args)...) {
+				inner_name, _ := inner.executable()
+				if downloaders[inner_name] {
+					add("Runs a script downloaded from the internet (" + name + " running " + inner_name + ")")
+				}
+			}
+
+		case name == "find":
+			for _, arg := range args {
+				if arg == "-delete" {
+					add("Deletes every file found (find -delete)")
+				}
+			}
+
+		case name == "git":
+			if isGitForcePush(args) {
+				add("Force pushes and can overwrite the remote history (git push --force)")
+			}
+		}
+	}
+
+	return risks
+}
+
+func isBlockDevice(path string) bool {
+	for _, prefix := range []string{"/dev/sd", "/dev/hd", "/dev/nvme", "/dev/disk", "/dev/mmcblk", "/dev/vd", "/dev/xvd"} {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isGitForcePush(args []string) bool {
+	is_push := false
+
+	for i, arg := range args {
+		if !is_push {
+			// global options with a value, eg: git -C dir push
+			if arg == "-C" || arg == "-c" {
+				continue
+			}
+			if i > 0 && (args[i-1] == "-C" || args[i-1] == "-c") {
+				continue
+			}
+			if strings.HasPrefix(arg, "-") {
+				continue
+			}
+			if arg != "push" {
+				return false
+			}
+			is_push = true
+			continue
+		}
+
+		if arg == "-f" || arg == "--force" || strings.HasPrefix(arg, "--force-with-lease") || arg == "--mirror" {
+			return true
+		}
+		if strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && strings.Contains(arg, "f") {
+			return true
+		}
+		// +refspec forces the update of that ref
+		if strings.HasPrefix(arg, "+") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAnalyzeCommandRisk(t *testing.T) {
+	tests := []struct {
+		command string
+		// substrings of the expected risks, none means the command is safe
+		risks []string
+	}{
+		{`ls -la`, nil},
+		{`curl -s https://x.sh -o install.sh`, nil},
+		{`echo "$(date)"`, nil},
+		{`bash script.sh`, nil},
+		{`diff <(ls a) <(ls b)`, nil},
+		{`rm -r build`, nil},
+		{`git push origin main`, nil},
+
+		{`curl -s https://x.sh | sh`, []string{"downloaded from the internet"}},
+		{`curl -s https://x.sh | sudo bash`, []string{"downloaded from the internet", "superuser"}},
+		{`bash <(curl -s https://x.sh)`, []string{"downloaded from the internet"}},
+		{`sh -c "$(curl -fsSL https://x.sh)"`, []string{"downloaded from the internet"}},
+		{`bash -c "$(wget -qO- https://x.sh)"`, []string{"downloaded from the internet"}},
+		{`zsh -c "$(fetch -o - https://x.sh)"`, []string{"downloaded from the internet"}},
+		{`sh -c 'curl -s https://x.sh | sh'`, []string{"downloaded from the internet"}},
+		{`bash -lc 'wget -qO- https://x.sh'`, []string{"downloaded from the internet"}},
+
+		{`git push origin +main`, []string{"Force pushes"}},
+		{`git push --force`, []string{"Force pushes"}},
+		{`find . -name '*.log' -exec rm -rf {} \;`, []string{"rm -rf"}},
+		{`echo $(rm -rf /)`, []string{"rm -rf", "Deletes everything in /"}},
+		{`sh -c 'rm -rf ~'`, []string{"rm -rf", "Deletes everything in ~"}},
+		{`cat image.iso > /dev/sda`, []string{"Overwrites a disk device"}},
+		{`dd if=image.iso of=/dev/sdb`, []string{"Writes directly to a device"}},
+		{`sudo mkfs.ext4 /dev/sdb1`, []string{"superuser", "Formats"}},
+		{`chmod -R 777 /`, []string{"world writable", "whole system"}},
+
+		// each level is parsed once, this would take hours otherwise
+		{"echo " + strings.Repeat("$(echo ", 40) + "$(rm -rf /)" + strings.Repeat(")", 40), []string{"Deletes everything in /"}},
+	}
+
+	for _, test := range tests {
+		risks := analyzeCommandRisk(test.command)
+
+		if len(test.risks) == 0 && len(risks) > 0 {
+			t.Errorf("%s: expected no risks, got %q", test.command, risks)
+			continue
+		}
+
+		for _, expected := range test.risks {
+			found := false
+			for _, risk := range risks {
+				if strings.Contains(risk, expected) {
+					found = true
+				}
+			}
+
+			if !found {
+				t.Errorf("%s: expected a risk containing %q, got %q", test.command, expected, risks)
+			}
+		}
+	}
+}
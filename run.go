@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const run_output_limit = 16 * 1024
+
+// max bytes of each output of a run kept in the history
+const history_output_limit = 1024
+
+// run_result is the outcome of running a command
+type run_result struct {
+	Stdout   string  `json:"stdout"`
+	Stderr   string  `json:"stderr"`
+	ExitCode int     `json:"exit_code"`
+	Signal   string  `json:"signal,omitempty"`
+	Duration float64 `json:"duration"` // seconds
+}
+
+func (r run_result) succeeded() bool {
+	return r.ExitCode == 0 && r.Signal == ""
+}
+
+/**
+* Headline of the run outcome, eg: "exit code 1" or "killed by signal: interrupt"
+**/
+func (r run_result) status() string {
+	if r.Signal != "" {
+		return "killed by signal: " + r.Signal
+	}
+
+	return fmt.Sprintf("exit code %d", r.ExitCode)
+}
+
+// tail_buffer keeps only the last bytes written to it so long running
+// commands don't fill the memory
+type tail_buffer struct {
+	data  []byte
+	limit int
+}
+
+func newTailBuffer(limit int) *tail_buffer {
+	return &tail_buffer{limit: limit}
+}
+
+func (b *tail_buffer) Write(p []byte) (int, error) {
+	b.data = append(b.data, p...)
+	if len(b.data) > b.limit {
+		b.data = b.data[len(b.data)-b.limit:]
+	}
+
+	return len(p), nil
+}
+
+func (b *tail_buffer) String() string {
+	return string(b.data)
+}
+
+/**
+* Copy of the run result to save in the history, with only the end of its outputs
+**/
+func historyRunResult(result run_result) *run_result {
+	for _, output := range []*string{&result.Stdout, &result.Stderr} {
+		if len(*output) > history_output_limit {
+			*output = "…" + strings.ToValidUTF8((*output)[len(*output)-history_output_limit:], "")
+		}
+	}
+
+	return &result
+}
+
+/**
+* Runs the command and records its outcome while still forwarding the
+* output to the given writers as it is produced
+**/
+func runAndRecord(c *exec.Cmd, stdout io.Writer, stderr io.Writer) (run_result, error) {
+	stdout_tail := newTailBuffer(run_output_limit)
+	stderr_tail := newTailBuffer(run_output_limit)
+
+	c.Stdout = io.MultiWriter(stdout, stdout_tail)
+	c.Stderr = io.MultiWriter(stderr, stderr_tail)
+
+	result, err := runCommand(c)
+	result.Stdout = stdout_tail.String()
+	result.Stderr = stderr_tail.String()
+
+	return result, err
+}
+
+/**
+* Runs the command with the outputs it was given and records its exit
+* code, signal and duration, but not its output
+**/
+func runCommand(c *exec.Cmd) (run_result, error) {
+	started_at := time.Now()
+	err := c.Run()
+
+	result := run_result{
+		Duration: time.Since(started_at).Seconds(),
+	}
+
+	var exit_err *exec.ExitError
+	if errors.As(err, &exit_err) {
+		result.ExitCode = exit_err.ExitCode()
+
+		if status, ok := exit_err.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			result.Signal = status.Signal().String()
+		}
+
+		return result, nil
+	}
+
+	if err != nil {
+		// the command could not even start
+		result.ExitCode = -1
+		return result, err
+	}
+
+	return result, nil
+}
+
+/**
+* Renders the recorded output of a run for the running command screen
+**/
+func renderRunOutput(result run_result) string {
+	s := ""
+
+	if result.Stderr != "" {
+		s += "stderr:\n" + result.Stderr + "\n"
+	}
+
+	if result.Stdout != "" {
+		if s != "" {
+			s += "\n"
+		}
+		s += "stdout:\n" + result.Stdout + "\n"
+	}
+
+	return s
+}
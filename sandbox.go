@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// limits of the working directory copy used by the dry run
+const (
+	dry_run_max_files = 20000
+	dry_run_max_bytes = 200 * 1024 * 1024
+	dry_run_timeout   = 60 * time.Second
+)
+
+// dry_run_result is what the command would do to the working directory
+type dry_run_result struct {
+	run      run_result
+	created  []string
+	modified []string
+	deleted  []string
+}
+
+type dryRunResultMsg struct {
+	result dry_run_result
+}
+
+type dryRunErrorMsg struct {
+	err error
+}
+
+/**
+* Runs the command inside a throwaway sandbox: the working directory is a
+* temporary copy and the rest of the filesystem is mounted read-only, with
+* no network. Then reports the files that were created, modified or deleted
+**/
+func dryRunCommand(command string) tea.Cmd {
+	return func() tea.Msg {
+		if runtime.GOOS != "linux" {
+			return dryRunErrorMsg{err: errors.New("dry run is only supported on Linux")}
+		}
+
+		bwrap, err := exec.LookPath("bwrap")
+		if err != nil {
+			return dryRunErrorMsg{err: errors.New("dry run needs bubblewrap (bwrap) to sandbox the command, install it with your package manager")}
+		}
+
+		cwd, err := os.Getwd()
+		if err != nil {
+			return dryRunErrorMsg{err: err}
+		}
+
+		sandbox_dir, err := os.MkdirTemp("", "clai-dry-run-")
+		if err != nil {
+			return dryRunErrorMsg{err: err}
+		}
+		defer os.RemoveAll(sandbox_dir)
+
+		err = copyDir(cwd, sandbox_dir)
+		if err != nil {
+			return dryRunErrorMsg{err: fmt.Errorf("error copying the working directory: %w", err)}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), dry_run_timeout)
+		defer cancel()
+
+		c := exec.CommandContext(ctx, bwrap,
+			"--ro-bind", "/", "/",
+			"--dev", "/dev",
+			"--proc", "/proc",
+			"--tmpfs", "/tmp",
+			"--bind", sandbox_dir, cwd,
+			"--chdir", cwd,
+			"--unshare-all",
+			"--die-with-parent",
+			"--new-session",
+			userShell(), "-c", command,
+		)
+
+		var stdout, stderr bytes.Buffer
+		run, err := runAndRecord(c, &stdout, &stderr)
+		if err != nil {
+			return dryRunErrorMsg{err: err}
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return dryRunErrorMsg{err: fmt.Errorf("dry run timed out after %s", dry_run_timeout)}
+		}
+
+		created, modified, deleted, err := diffDirs(cwd, sandbox_dir)
+		if err != nil {
+			return dryRunErrorMsg{err: fmt.Errorf("error comparing the working directory: %w", err)}
+		}
+
+		return dryRunResultMsg{
+			result: dry_run_result{
+				run:      run,
+				created:  created,
+				modified: modified,
+				deleted:  deleted,
+			},
+		}
+	}
+}
+
+/**
+* Copies the src directory into dst keeping file modes and symlinks.
+* Fails when the directory is too big to be copied for a dry run
+**/
+func copyDir(src string, dst string) error {
+	files := 0
+	var total int64
+
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// the copy is inside the source when the working directory is the
+		// temp dir or one of its parents, it would copy itself otherwise
+		if path == dst {
+			return filepath.SkipDir
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		files++
+		total += info.Size()
+		if files > dry_run_max_files || total > dry_run_max_bytes {
+			return errors.New("the working directory is too big for a dry run")
+		}
+
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+
+		default:
+			// sockets, devices, pipes... are not copied
+			return nil
+		}
+	})
+}
+
+func copyFile(src string, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	// the permissions given to OpenFile are reduced by the umask, the copy
+	// would look modified, eg: a group writable file
+	if err := out.Chmod(perm); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	return err
+}
+
+/**
+* Compares the original directory with the sandbox copy after the run
+**/
+func diffDirs(original string, sandbox string) (created []string, modified []string, deleted []string, err error) {
+	before, err := listFiles(original, sandbox)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	after, err := listFiles(sandbox, "")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	for rel, after_info := range after {
+		before_info, existed := before[rel]
+		if !existed {
+			created = append(created, rel)
+			continue
+		}
+
+		if before_info.IsDir() || after_info.IsDir() {
+			continue
+		}
+
+		if before_info.Mode() != after_info.Mode() || before_info.Size() != after_info.Size() {
+			modified = append(modified, rel)
+			continue
+		}
+
+		same, err := sameContent(filepath.Join(original, rel), filepath.Join(sandbox, rel), after_info)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if !same {
+			modified = append(modified, rel)
+		}
+	}
+
+	for rel := range before {
+		if _, exists := after[rel]; !exists {
+			deleted = append(deleted, rel)
+		}
+	}
+
+	sort.Strings(created)
+	sort.Strings(modified)
+	sort.Strings(deleted)
+
+	return created, modified, deleted, nil
+}
+
+/**
+* Lists the files under root, skipping the skip directory, eg: the sandbox
+* copy when it is inside the working directory
+**/
+func listFiles(root string, skip string) (map[string]fs.FileInfo, error) {
+	files := map[string]fs.FileInfo{}
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == root {
+			return nil
+		}
+		if path == skip {
+			return filepath.SkipDir
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		// only what copyDir copies is compared
+		if !info.IsDir() && !info.Mode().IsRegular() && info.Mode()&os.ModeSymlink == 0 {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files[rel] = info
+
+		return nil
+	})
+
+	return files, err
+}
+
+func sameContent(a string, b string, info fs.FileInfo) (bool, error) {
+	if info.Mode()&os.ModeSymlink != 0 {
+		link_a, err := os.Readlink(a)
+		if err != nil {
+			return false, err
+		}
+		link_b, err := os.Readlink(b)
+		if err != nil {
+			return false, err
+		}
+		return link_a == link_b, nil
+	}
+
+	content_a, err := os.ReadFile(a)
+	if err != nil {
+		return false, err
+	}
+	content_b, err := os.ReadFile(b)
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(content_a, content_b), nil
+}
+
+/**
+* Renders the outcome of the dry run for the dry run screen
+**/
+func renderDryRunResult(result dry_run_result) string {
+	s := ""
+
+	if len(result.created) == 0 && len(result.modified) == 0 && len(result.deleted) == 0 {
+		s += "No files would be created, modified or deleted in the working directory\n"
+	}
+
+	sections := []struct {
+		title  string
+		prefix string
+		files  []string
+	}{
+		{"Created", "+ ", result.created},
+		{"Modified", "~ ", result.modified},
+		{"Deleted", "- ", result.deleted},
+	}
+
+	for _, section := range sections {
+		if len(section.files) == 0 {
+			continue
+		}
+
+		s += fmt.Sprintf("%s (%d)\n", section.title, len(section.files))
+		for _, file := range section.files {
+			s += "  " + section.prefix + file + "\n"
+		}
+		s += "\n"
+	}
+
+	if output := renderRunOutput(result.run); output != "" {
+		s += strings.TrimRight(output, "\n") + "\n"
+	}
+
+	return s
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// max time spent by a collector running an external program
+const shell_context_command_timeout = time.Second
+
+// max number of entries of the working directory listed in the prompt
+const shell_context_max_files = 30
+
+// shell_context_collector adds one line of information about the machine
+// to the command generation prompt, eg: "SHELL: zsh 5.9".
+// To add a new one, add an entry to shell_context_collectors and a toggle to context_config
+type shell_context_collector struct {
+	name    string
+	enabled func(ctx_cfg context_config) bool
+	// collect returns an empty string when there is nothing to tell
+	collect func(prompt string) string
+}
+
+var shell_context_collectors = []shell_context_collector{
+	{
+		name:    "SHELL",
+		enabled: func(ctx_cfg context_config) bool { return ctx_cfg.Shell },
+		collect: collectShell,
+	},
+	{
+		name:    "DISTRO",
+		enabled: func(ctx_cfg context_config) bool { return ctx_cfg.Distro },
+		collect: collectDistro,
+	},
+	{
+		name:    "PACKAGE_MANAGER",
+		enabled: func(ctx_cfg context_config) bool { return ctx_cfg.PackageManager },
+		collect: collectPackageManager,
+	},
+	{
+		name:    "CWD",
+		enabled: func(ctx_cfg context_config) bool { return ctx_cfg.Cwd },
+		collect: collectCwd,
+	},
+	{
+		name:    "GIT",
+		enabled: func(ctx_cfg context_config) bool { return ctx_cfg.Git },
+		collect: collectGitStatus,
+	},
+	{
+		name:    "FILES_IN_CWD",
+		enabled: func(ctx_cfg context_config) bool { return ctx_cfg.Files },
+		collect: collectFiles,
+	},
+	{
+		name:    "INSTALLED_PROGRAMS",
+		enabled: func(ctx_cfg context_config) bool { return ctx_cfg.Binaries },
+		collect: collectInstalledBinaries,
+	},
+}
+
+/**
+* Runs the enabled collectors and returns the lines to add to the
+* command generation prompt
+**/
+func collectShellContext(ctx_cfg context_config, prompt string) []string {
+	var lines []string
+
+	for _, collector := range shell_context_collectors {
+		if !collector.enabled(ctx_cfg) {
+			continue
+		}
+
+		if value := collector.collect(prompt); value != "" {
+			lines = append(lines, collector.name+": "+value)
+		}
+	}
+
+	return lines
+}
+
+/**
+* Runs a program and returns its trimmed output, or an empty string
+* when it fails or takes too long
+**/
+func commandOutput(name string, args ...string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), shell_context_command_timeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, name, args...).Output()
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(output))
+}
+
+func collectShell(prompt string) string {
+	shell := userShell()
+	name := filepath.Base(shell)
+
+	// eg: "GNU bash, version 5.2.15(1)-release" or "zsh 5.9 (x86_64-apple-darwin22.0)"
+	version_line := strings.SplitN(commandOutput(shell, "--version"), "\n", 2)[0]
+	version := regexp.MustCompile(`\d+(\.\d+)+`).FindString(version_line)
+	if version == "" {
+		return name
+	}
+
+	return name + " " + version
+}
+
+func collectDistro(prompt string) string {
+	if version := commandOutput("sw_vers", "-productVersion"); version != "" {
+		return "macOS " + version
+	}
+
+	file, err := os.Open("/etc/os-release")
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "PRETTY_NAME=") {
+			value := strings.TrimPrefix(line, "PRETTY_NAME=")
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				return unquoted
+			}
+			return strings.Trim(value, `'"`)
+		}
+	}
+
+	return ""
+}
+
+func collectPackageManager(prompt string) string {
+	for _, name := range []string{"brew", "apt", "dnf", "yum", "pacman", "zypper", "apk", "emerge", "nix", "port", "pkg"} {
+		if _, err := exec.LookPath(name); err == nil {
+			return name
+		}
+	}
+
+	return ""
+}
+
+func collectCwd(prompt string) string {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+
+	return cwd
+}
+
+/**
+* Returns the current branch and the number of changed files of the git
+* repository the working directory is in, eg: "branch main, 3 changed files"
+**/
+func collectGitStatus(prompt string) string {
+	if commandOutput("git", "rev-parse", "--is-inside-work-tree") != "true" {
+		return ""
+	}
+
+	status := "inside a git repository"
+
+	if branch := commandOutput("git", "branch", "--show-current"); branch != "" {
+		status += ", branch " + branch
+	}
+
+	changes := commandOutput("git", "status", "--porcelain")
+	if changes == "" {
+		status += ", clean working tree"
+	} else {
+		status += ", " + strconv.Itoa(len(strings.Split(changes, "\n"))) + " changed files"
+	}
+
+	return status
+}
+
+func collectFiles(prompt string) string {
+	entries, err := os.ReadDir(".")
+	if err != nil {
+		return ""
+	}
+
+	var names []string
+	for i, entry := range entries {
+		if i == shell_context_max_files {
+			names = append(names, "… ("+strconv.Itoa(len(entries)-i)+" more)")
+			break
+		}
+
+		name := entry.Name()
+		if entry.IsDir() {
+			name += "/"
+		}
+		names = append(names, name)
+	}
+
+	return strings.Join(names, " ")
+}
+
+/**
+* Returns the words of the prompt that are programs installed on the
+* machine, eg: "convert video.mp4 with ffmpeg" -> "ffmpeg"
+**/
+func collectInstalledBinaries(prompt string) string {
+	installed := map[string]bool{}
+
+	for _, word := range regexp.MustCompile(`[A-Za-z][A-Za-z0-9._+-]*`).FindAllString(prompt, -1) {
+		word = strings.TrimRight(strings.ToLower(word), ".")
+		if len(word) < 2 || installed[word] {
+			continue
+		}
+
+		if _, err := exec.LookPath(word); err == nil {
+			installed[word] = true
+		}
+	}
+
+	var names []string
+	for name := range installed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return strings.Join(names, ", ")
+}
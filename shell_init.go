@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// The shell integration binds ctrl+g to a function that opens clAI on the
+// terminal and puts the accepted command in the command line for editing.
+// clAI writes the accepted command into the file given with -shell-output.
+// It also records the last command that failed for clai fix, the
+// __CLAI_LAST_FAILED_COMMAND_FILE__ placeholder is replaced with its path.
+
+const bash_init_script = `
+_clai_insert() {
+  local tmp cmd
+  tmp="$(mktemp)" || return
+  clai -shell-output "$tmp" </dev/tty >/dev/tty
+  cmd="$(cat "$tmp")"
+  rm -f "$tmp"
+  if [ -n "$cmd" ]; then
+    READLINE_LINE="$cmd"
+    READLINE_POINT=${#READLINE_LINE}
+  fi
+}
+bind -x '"\C-g": _clai_insert'
+
+_clai_record_failure() {
+  local exit_code=$?
+  if [ $exit_code -ne 0 ]; then
+    printf '%s\n%s\n' "$exit_code" "$(HISTTIMEFORMAT= builtin history 1 | sed 's/^ *[0-9]* *//')" >__CLAI_LAST_FAILED_COMMAND_FILE__ 2>/dev/null
+  fi
+  return $exit_code
+}
+PROMPT_COMMAND="_clai_record_failure${PROMPT_COMMAND:+;$PROMPT_COMMAND}"
+`
+
+const zsh_init_script = `
+_clai_insert() {
+  local tmp cmd
+  tmp="$(mktemp)" || return
+  clai -shell-output "$tmp" </dev/tty >/dev/tty
+  cmd="$(<"$tmp")"
+  rm -f "$tmp"
+  if [[ -n "$cmd" ]]; then
+    BUFFER="$cmd"
+    CURSOR=${#BUFFER}
+  fi
+  zle reset-prompt
+}
+zle -N _clai_insert
+bindkey '^G' _clai_insert
+
+_clai_record_failure() {
+  local exit_code=$?
+  if [[ $exit_code -ne 0 ]]; then
+    printf '%s\n%s\n' "$exit_code" "$(fc -ln -1)" >__CLAI_LAST_FAILED_COMMAND_FILE__ 2>/dev/null
+  fi
+}
+autoload -Uz add-zsh-hook
+add-zsh-hook precmd _clai_record_failure
+`
+
+const fish_init_script = `
+function _clai_insert
+    set -l tmp (mktemp); or return
+    clai -shell-output $tmp </dev/tty >/dev/tty
+    set -l cmd (cat $tmp | string collect)
+    rm -f $tmp
+    if test -n "$cmd"
+        commandline --replace -- $cmd
+        commandline --cursor (string length -- $cmd)
+    end
+    commandline -f repaint
+end
+bind \cg _clai_insert
+
+function _clai_record_failure --on-event fish_postexec
+    set -l exit_code $status
+    if test $exit_code -ne 0
+        printf '%s\n%s\n' $exit_code "$argv[1]" >__CLAI_LAST_FAILED_COMMAND_FILE__ 2>/dev/null
+    end
+end
+`
+
+/**
+* Returns the init script of the shell integration, eg: eval "$(clai init bash)"
+**/
+func shellInitScript(shell string) (string, error) {
+	var script string
+
+	switch shell {
+	case "bash":
+		script = bash_init_script
+	case "zsh":
+		script = zsh_init_script
+	case "fish":
+		script = fish_init_script
+	default:
+		return "", fmt.Errorf("unsupported shell %q, use one of: bash, zsh, fish", shell)
+	}
+
+	// single quoted so the path is not expanded, eg: ~/Library/Application Support
+	quoted_path := "'" + strings.ReplaceAll(lastFailedCommandPath(), "'", `'\''`) + "'"
+
+	return strings.ReplaceAll(script, "__CLAI_LAST_FAILED_COMMAND_FILE__", quoted_path), nil
+}
+
+type writeCommandToShellResult struct{}
+
+type writeCommandToShellError struct {
+	err error
+}
+
+/**
+* Hands the accepted command over to the shell integration
+**/
+func writeCommandToShell(file_path string, command string) tea.Cmd {
+	return func() tea.Msg {
+		err := os.WriteFile(file_path, []byte(command), 0600)
+		if err != nil {
+			return writeCommandToShellError{err: fmt.Errorf("❌ error sending the command to the shell: %w", err)}
+		}
+
+		return writeCommandToShellResult{}
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// shell_command is a simple command of a shell command line,
+// eg: `curl -s x.sh | sh` has the simple commands `curl -s x.sh` and `sh`
+type shell_command struct {
+	args      []string
+	redirects []shell_redirect
+	// piped is true when stdin comes from the previous command through |
+	piped bool
+	// substitutions are the commands run by $(...), <(...) or >(...) in
+	// the arguments, eg: `bash <(curl -s x.sh)`
+	substitutions []shell_command
+}
+
+type shell_redirect struct {
+	op     string // eg: >, >>, <, 2>
+	target string
+}
+
+// wrapper commands that run the command given in their arguments
+var shell_command_wrappers = map[string]bool{
+	"sudo":    true,
+	"doas":    true,
+	"env":     true,
+	"nohup":   true,
+	"time":    true,
+	"nice":    true,
+	"exec":    true,
+	"command": true,
+	"builtin": true,
+	"xargs":   true,
+	"timeout": true,
+}
+
+// keywords that can come before a command, eg: `for f in *; do rm $f; done`
+var shell_command_keywords = map[string]bool{
+	"if":    true,
+	"then":  true,
+	"else":  true,
+	"elif":  true,
+	"while": true,
+	"until": true,
+	"do":    true,
+	"!":     true,
+	"{":     true,
+}
+
+// wrapper flags that take a value as the next argument
+var shell_wrapper_flags_with_value = map[string]bool{
+	"-u": true, // sudo, doas
+	"-g": true, // sudo
+	"-n": true, // nice, xargs
+	"-I": true, // xargs
+	"-s": true, // timeout, xargs
+}
+
+/**
+* Splits a command line into its simple commands. It understands quotes,
+* escapes, pipes, command separators (; && || &), redirections and
+* command substitutions, which is enough to inspect the generated commands
+* without running them
+**/
+func parseShellCommands(line string) []shell_command {
+	var commands []shell_command
+	var current shell_command
+	runes := []rune(line)
+	var word strings.Builder
+	in_word := false
+	pending_redirect := ""
+	// commands run by the substitutions, they are listed after the others
+	var substitution_commands []shell_command
+
+	// reads the command substitution whose ( is at i, i ends on the closing )
+	readSubstitution := func(i int) int {
+		depth := 0
+		start := i + 1
+		for ; i < len(runes); i++ {
+			if runes[i] == '(' {
+				depth++
+			} else if runes[i] == ')' {
+				depth--
+				if depth == 0 {
+					break
+				}
+			}
+		}
+
+		end := i
+		if end > len(runes) {
+			end = len(runes)
+		}
+
+		// parsed once, nested substitutions would be parsed again at every level otherwise
+		parsed := parseShellCommands(string(runes[start:end]))
+		current.substitutions = append(current.substitutions, parsed...)
+		substitution_commands = append(substitution_commands, parsed...)
+
+		return i
+	}
+
+	flushWord := func() {
+		if !in_word {
+			return
+		}
+
+		if pending_redirect != "" {
+			current.redirects = append(current.redirects, shell_redirect{op: pending_redirect, target: word.String()})
+			pending_redirect = ""
+		} else {
+			current.args = append(current.args, word.String())
+		}
+
+		word.Reset()
+		in_word = false
+	}
+
+	flushCommand := func(next_is_piped bool) {
+		flushWord()
+		if len(current.args) > 0 || len(current.redirects) > 0 || len(current.substitutions) > 0 {
+			commands = append(commands, current)
+		}
+		current = shell_command{piped: next_is_piped}
+	}
+
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+
+		switch {
+		case r == '\\' && i+1 < len(runes):
+			i++
+			word.WriteRune(runes[i])
+			in_word = true
+
+		case r == '\'':
+			in_word = true
+			for i++; i < len(runes) && runes[i] != '\''; i++ {
+				word.WriteRune(runes[i])
+			}
+
+		case r == '"':
+			in_word = true
+			for i++; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) && strings.ContainsRune(`"\$`+"`", runes[i+1]) {
+					i++
+				}
+
+				// command substitution in a string, eg: "$(date)"
+				if runes[i] == '$' && i+1 < len(runes) && runes[i+1] == '(' {
+					i = readSubstitution(i + 1)
+					continue
+				}
+
+				word.WriteRune(runes[i])
+			}
+
+		case r == ' ' || r == '\t':
+			flushWord()
+
+		case r == '|':
+			if i+1 < len(runes) && runes[i+1] == '|' {
+				i++
+				flushCommand(false)
+			} else {
+				flushCommand(true)
+			}
+
+		// $( starts a command substitution, the inner command is inspected on its own
+		case r == '(' && in_word && strings.HasSuffix(word.String(), "$"):
+			trimmed := strings.TrimSuffix(word.String(), "$")
+			word.Reset()
+			word.WriteString(trimmed)
+			in_word = trimmed != ""
+			i = readSubstitution(i)
+
+		// <( and >( are process substitutions, eg: diff <(ls a) <(ls b)
+		case r == '(' && !in_word && (pending_redirect == "<" || pending_redirect == ">"):
+			pending_redirect = ""
+			i = readSubstitution(i)
+
+		case r == ';' || r == '\n' || r == '&' || r == '(' || r == ')' || r == '`':
+			if r == '&' && i+1 < len(runes) && runes[i+1] == '&' {
+				i++
+			}
+			flushCommand(false)
+
+		case r == '>' || r == '<':
+			op := string(r)
+			// file descriptor of the redirection, eg: 2>
+			if in_word && (word.String() == "1" || word.String() == "2" || word.String() == "&") {
+				op = word.String() + op
+				word.Reset()
+				in_word = false
+			}
+			flushWord()
+			if i+1 < len(runes) && (runes[i+1] == '>' || runes[i+1] == '&') {
+				i++
+				op += string(runes[i])
+			}
+			pending_redirect = op
+
+		default:
+			word.WriteRune(r)
+			in_word = true
+		}
+	}
+	flushCommand(false)
+
+	return append(commands, substitution_commands...)
+}
+
+/**
+* Returns the name of the program run by the simple command and its
+* arguments, skipping variable assignments, keywords like do and wrappers
+* like sudo or env
+**/
+func (c shell_command) executable() (string, []string) {
+	args := c.args
+
+	for len(args) > 0 {
+		name := filepath.Base(args[0])
+
+		// variable assignments, eg: FOO=bar cmd
+		if strings.Contains(args[0], "=") && !strings.HasPrefix(args[0], "=") {
+			args = args[1:]
+			continue
+		}
+
+		if shell_command_keywords[args[0]] {
+			args = args[1:]
+			continue
+		}
+
+		if !shell_command_wrappers[name] {
+			return name, args[1:]
+		}
+
+		args = args[1:]
+		for len(args) > 0 && (strings.HasPrefix(args[0], "-") || (name == "env" && strings.Contains(args[0], "="))) {
+			if shell_wrapper_flags_with_value[args[0]] {
+				args = args[1:]
+			}
+			args = args[1:]
+		}
+
+		// timeout takes the duration before the command
+		if name == "timeout" && len(args) > 0 {
+			args = args[1:]
+		}
+	}
+
+	return "", nil
+}
+
+/**
+* Returns true when the simple command runs through sudo or doas,
+* eg: `sudo rm x` or `xargs sudo rm`
+**/
+func (c shell_command) isPrivileged() bool {
+	for _, arg := range c.args {
+		if strings.Contains(arg, "=") || strings.HasPrefix(arg, "-") || shell_command_keywords[arg] {
+			continue
+		}
+
+		name := filepath.Base(arg)
+		if name == "sudo" || name == "doas" {
+			return true
+		}
+		if !shell_command_wrappers[name] {
+			return false
+		}
+	}
+
+	return false
+}
+
+/**
+* Returns the commands of the script given with -c to a shell interpreter,
+* eg: `sh -c 'curl -s x.sh | sh'`
+**/
+func interpreterScriptCommands(args []string) []shell_command {
+	for i, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			return nil
+		}
+
+		// eg: -c, -lc, -ec
+		if !strings.HasPrefix(arg, "--") && strings.Contains(arg, "c") && i+1 < len(args) {
+			return parseShellCommands(args[i+1])
+		}
+	}
+
+	return nil
+}
+
+/**
+* Returns the commands run by find with -exec, -execdir, -ok or -okdir
+**/
+func findExecCommands(args []string) []shell_command {
+	var commands []shell_command
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-exec", "-execdir", "-ok", "-okdir":
+			var exec_args []string
+			for i++; i < len(args) && args[i] != ";" && args[i] != "+"; i++ {
+				exec_args = append(exec_args, args[i])
+			}
+			if len(exec_args) > 0 {
+				commands = append(commands, shell_command{args: exec_args})
+			}
+		}
+	}
+
+	return commands
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// shell builtins and keywords, they are never found in $PATH
+var shell_builtins = map[string]bool{
+	".": true, ":": true, "[": true, "[[": true, "alias": true, "bg": true, "bind": true,
+	"break": true, "builtin": true, "case": true, "cd": true, "command": true, "continue": true,
+	"declare": true, "do": true, "done": true, "echo": true, "elif": true, "else": true,
+	"esac": true, "eval": true, "exec": true, "exit": true, "export": true, "false": true,
+	"fg": true, "fi": true, "for": true, "function": true, "getopts": true, "hash": true,
+	"history": true, "if": true, "jobs": true, "kill": true, "let": true, "local": true,
+	"popd": true, "printf": true, "pushd": true, "pwd": true, "read": true, "readonly": true,
+	"return": true, "select": true, "set": true, "shift": true, "source": true, "test": true,
+	"then": true, "time": true, "trap": true, "true": true, "type": true, "typeset": true,
+	"ulimit": true, "umask": true, "unalias": true, "unset": true, "until": true, "wait": true,
+	"while": true, "{": true, "}": true, "!": true,
+}
+
+// install commands of the package managers detected by collectPackageManager
+var install_commands = map[string]string{
+	"brew":   "brew install",
+	"apt":    "sudo apt install",
+	"dnf":    "sudo dnf install",
+	"yum":    "sudo yum install",
+	"pacman": "sudo pacman -S",
+	"zypper": "sudo zypper install",
+	"apk":    "sudo apk add",
+	"emerge": "sudo emerge",
+	"nix":    "nix profile install",
+	"port":   "sudo port install",
+	"pkg":    "sudo pkg install",
+}
+
+/**
+* Returns the programs run by the command and the required tools that are
+* not installed, skipping shell builtins and the scripts referenced by path
+**/
+func findMissingTools(command string, required_tools []string) []string {
+	commands := parseShellCommands(command)
+
+	for _, c := range commands {
+		if name, args := c.executable(); name == "find" {
+			commands = append(commands, findExecCommands(args)...)
+		}
+	}
+
+	missing := map[string]bool{}
+	for _, c := range commands {
+		name, _ := c.executable()
+		if name == "" || shell_builtins[name] || missing[name] {
+			continue
+		}
+
+		// eg: ./build.sh or ~/bin/tool
+		if path := programPath(c); strings.Contains(path, "/") {
+			continue
+		}
+
+		if _, err := exec.LookPath(name); err != nil {
+			missing[name] = true
+		}
+	}
+
+	for _, name := range required_tools {
+		if name == "" || shell_builtins[name] || strings.Contains(name, "/") || missing[name] {
+			continue
+		}
+
+		if _, err := exec.LookPath(name); err != nil {
+			missing[name] = true
+		}
+	}
+
+	var names []string
+	for name := range missing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+/**
+* Returns the program as written in the command, eg: "./build.sh" for `sudo ./build.sh`
+**/
+func programPath(c shell_command) string {
+	name, args := c.executable()
+
+	// executable() drops the directory, find the argument it comes from
+	index := len(c.args) - len(args) - 1
+	if index < 0 || index >= len(c.args) {
+		return name
+	}
+
+	return c.args[index]
+}
+
+/**
+* Returns the command that installs the tools with the package manager of
+* the machine, or an empty string when none was found. The package names
+* are a guess: they usually but not always match the program names
+**/
+func installToolsCommand(tools []string) string {
+	install_command, ok := install_commands[collectPackageManager("")]
+	if !ok || len(tools) == 0 {
+		return ""
+	}
+
+	if install_command == "nix profile install" {
+		return install_command + " nixpkgs#" + strings.Join(tools, " nixpkgs#")
+	}
+
+	return install_command + " " + strings.Join(tools, " ")
+}
+
+/**
+* Follow-up prompt asking to generate the command again without the missing tools
+**/
+func installedToolsOnlyPrompt(tools []string) string {
+	return "Only use programs that are installed. These are NOT installed, do not use them: " + strings.Join(tools, ", ")
+}